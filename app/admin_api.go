@@ -0,0 +1,271 @@
+package app
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// wrapAdminAuth requires the admin token as a bearer token.
+func (app *App) wrapAdminAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization || subtle.ConstantTimeCompare([]byte(token), []byte(app.options.AdminToken)) != 1 {
+			app.metrics.authFailed(authFailureAdminToken)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="GoTTY"`)
+			http.Error(w, "authorization failed", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// makeAdminHandler returns the handler of the admin API under path/api/.
+func (app *App) makeAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(app.path+"/api/links", app.handleAdminLinks)
+	mux.HandleFunc(app.path+"/api/links/", app.handleAdminLink)
+	mux.HandleFunc(app.path+"/api/sessions", app.handleAdminSessions)
+	mux.HandleFunc(app.path+"/api/sessions/", app.handleAdminSession)
+	mux.HandleFunc(app.path+"/api/clients/", app.handleAdminClient)
+	mux.HandleFunc(app.path+"/api/broadcast", app.handleAdminBroadcast)
+	return app.wrapAdminAuth(mux)
+}
+
+type linkRequest struct {
+	Role      string `json:"role"`
+	ExpiresIn int    `json:"expires_in"` // seconds
+	MaxUses   int    `json:"max_uses"`
+}
+
+type linkResponse struct {
+	shareLink
+	URL string `json:"url"`
+}
+
+// handleAdminLinks lists share links with GET, and creates one with POST.
+func (app *App) handleAdminLinks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		links := app.links.list()
+		response := make([]linkResponse, 0, len(links))
+		for _, link := range links {
+			response = append(response, linkResponse{link, app.shareLinkURL(r, link.ID)})
+		}
+		writeJSON(w, http.StatusOK, response)
+
+	case "POST":
+		request := linkRequest{Role: RoleReadOnly, ExpiresIn: 3600, MaxUses: 1}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Malformed request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Role != RoleReadOnly && request.Role != RoleReadWrite {
+			http.Error(w, "Unknown role: "+request.Role, http.StatusBadRequest)
+			return
+		}
+		if request.ExpiresIn <= 0 || request.MaxUses < 0 {
+			http.Error(w, "expires_in must be positive and max_uses must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		link := app.links.create(request.Role, time.Duration(request.ExpiresIn)*time.Second, request.MaxUses)
+		app.logger.with("remote_addr", r.RemoteAddr).infof("Share link %s created (%s, %d uses, expires %s)",
+			link.ID[:8], link.Role, link.MaxUses, link.Expires.Format(time.RFC3339))
+		writeJSON(w, http.StatusCreated, linkResponse{link, app.shareLinkURL(r, link.ID)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminLink revokes a share link with DELETE
+// and disconnects the clients which came with it.
+func (app *App) handleAdminLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, app.path+"/api/links/")
+	if !app.links.revoke(id) {
+		http.Error(w, "No such link", http.StatusNotFound)
+		return
+	}
+	app.disconnectLink(id)
+	app.logger.with("remote_addr", r.RemoteAddr).infof("Share link %s revoked", id[:8])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type sessionInfo struct {
+	ID       string       `json:"id"`
+	Pid      int          `json:"pid,omitempty"`
+	Argv     []string     `json:"argv,omitempty"`
+	Started  time.Time    `json:"started"`
+	Columns  uint16       `json:"columns"`
+	Rows     uint16       `json:"rows"`
+	BytesIn  int64        `json:"bytes_in"`
+	BytesOut int64        `json:"bytes_out"`
+	Clients  []clientInfo `json:"clients"`
+
+	Limits      *ResourceLimits `json:"limits,omitempty"`
+	LimitMethod string          `json:"limit_method,omitempty"`
+}
+
+type clientInfo struct {
+	ID         string    `json:"id"`
+	RemoteAddr string    `json:"remote_addr"`
+	User       string    `json:"user"`
+	Role       string    `json:"role"`
+	Connected  time.Time `json:"connected"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	Columns    uint16    `json:"columns"`
+	Rows       uint16    `json:"rows"`
+}
+
+type signalRequest struct {
+	Signal string `json:"signal"`
+}
+
+type broadcastRequest struct {
+	Message  string `json:"message"`
+	Session  string `json:"session"`  // all sessions when empty
+	Duration int    `json:"duration"` // seconds, 0 to keep the message shown
+}
+
+// handleAdminSessions lists running sessions and their clients with GET.
+func (app *App) handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions := app.runningSessions()
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// handleAdminSession closes a session with DELETE,
+// and signals its process with POST to <id>/signal.
+func (app *App) handleAdminSession(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, app.path+"/api/sessions/"), "/", 2)
+	s := app.findSession(parts[0])
+	if s == nil {
+		http.Error(w, "No such session", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == "DELETE":
+		s.logger.infof("Closing session by request from %s", r.RemoteAddr)
+		s.close()
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(parts) == 2 && parts[1] == "signal" && r.Method == "POST":
+		process, ok := s.slave.(Process)
+		if !ok {
+			http.Error(w, "Session has no process to signal", http.StatusBadRequest)
+			return
+		}
+		var request signalRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Malformed request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sig, err := parseSignal(request.Signal)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.logger.infof("Sending signal %d (%s) by request from %s", int(sig), sig, r.RemoteAddr)
+		if err := process.Signal(sig); err != nil {
+			http.Error(w, "Failed to send signal: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdminClient closes the connection of a client with DELETE.
+func (app *App) handleAdminClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, app.path+"/api/clients/")
+	for _, s := range app.runningSessions() {
+		for _, client := range s.attachedClients() {
+			if client.id == id {
+				client.logger.infof("Disconnecting client by request from %s", r.RemoteAddr)
+				client.connection.Close()
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+	}
+	http.Error(w, "No such client", http.StatusNotFound)
+}
+
+// handleAdminBroadcast shows a message over the terminal of clients with POST.
+func (app *App) handleAdminBroadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request := broadcastRequest{Duration: 5}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Malformed request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Message == "" || request.Duration < 0 {
+		http.Error(w, "message must not be empty and duration must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	overlay := map[string]interface{}{"message": request.Message, "duration": nil}
+	if request.Duration > 0 {
+		overlay["duration"] = request.Duration * 1000
+	}
+	message, _ := json.Marshal(overlay)
+	message = append([]byte{ShowOverlay}, message...)
+
+	sent := 0
+	for _, s := range app.runningSessions() {
+		if request.Session != "" && s.id != request.Session {
+			continue
+		}
+		for _, client := range s.attachedClients() {
+			if err := client.write(message); err != nil {
+				client.logger.warnf("Failed to send message: %v", err)
+				continue
+			}
+			sent++
+		}
+	}
+	app.logger.with("remote_addr", r.RemoteAddr).infof("Broadcast a message to %d clients", sent)
+	writeJSON(w, http.StatusOK, map[string]int{"clients": sent})
+}
+
+func (app *App) shareLinkURL(r *http.Request, id string) string {
+	return app.absoluteURL(r, app.path+"/s/"+id+"/")
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to the admin API and returns the response with its body.
+func adminRequest(t *testing.T, server *httptest.Server, method string, path string, authorization string, body string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, server.URL+"/OneAPM/ServerWebConsole/api/"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	_, server := adminTestServer(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"token prefix", "Bearer admin-", http.StatusUnauthorized},
+		{"empty bearer token", "Bearer ", http.StatusUnauthorized},
+		{"token without bearer", "admin-token", http.StatusUnauthorized},
+		{"basic authentication", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"token", "Bearer admin-token", http.StatusOK},
+	}
+	for _, test := range tests {
+		for _, path := range []string{"links", "sessions"} {
+			resp, _ := adminRequest(t, server, "GET", path, test.authorization, "")
+			if resp.StatusCode != test.status {
+				t.Errorf("%s: expected %d for %s, got %d", test.name, test.status, path, resp.StatusCode)
+			}
+			if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("%s: no WWW-Authenticate header for %s", test.name, path)
+			}
+		}
+	}
+}
+
+func TestAdminMethods(t *testing.T) {
+	_, server := adminTestServer(t)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"PUT", "links", http.StatusMethodNotAllowed},
+		{"DELETE", "links", http.StatusMethodNotAllowed},
+		{"GET", "links/unknown", http.StatusMethodNotAllowed},
+		{"POST", "links/unknown", http.StatusMethodNotAllowed},
+		{"DELETE", "links/unknown", http.StatusNotFound},
+		{"POST", "sessions", http.StatusMethodNotAllowed},
+		{"DELETE", "sessions", http.StatusMethodNotAllowed},
+		{"DELETE", "sessions/unknown", http.StatusNotFound},
+		{"GET", "clients/unknown", http.StatusMethodNotAllowed},
+		{"DELETE", "clients/unknown", http.StatusNotFound},
+		{"GET", "broadcast", http.StatusMethodNotAllowed},
+		{"DELETE", "broadcast", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		resp, _ := adminRequest(t, server, test.method, test.path, "Bearer admin-token", "")
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s: expected %d, got %d", test.method, test.path, test.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminLinks(t *testing.T) {
+	app, server := adminTestServer(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"malformed", "{", http.StatusBadRequest},
+		{"unknown role", `{"role": "admin"}`, http.StatusBadRequest},
+		{"non-positive expiry", `{"expires_in": 0}`, http.StatusBadRequest},
+		{"negative uses", `{"max_uses": -1}`, http.StatusBadRequest},
+		{"defaults", `{}`, http.StatusCreated},
+		{"read-write", `{"role": "read-write", "expires_in": 60, "max_uses": 0}`, http.StatusCreated},
+	}
+	created := []linkResponse{}
+	for _, test := range tests {
+		resp, data := adminRequest(t, server, "POST", "links", "Bearer admin-token", test.body)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected %d, got %d", test.name, test.status, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusCreated {
+			continue
+		}
+		var link linkResponse
+		if err := json.Unmarshal(data, &link); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !strings.HasSuffix(link.URL, "/OneAPM/ServerWebConsole/s/"+link.ID+"/") {
+			t.Errorf("%s: unexpected URL %s", test.name, link.URL)
+		}
+		created = append(created, link)
+	}
+	if len(created) != 2 {
+		t.Fatalf("Expected 2 links, got %d", len(created))
+	}
+	if created[0].Role != RoleReadOnly || created[0].MaxUses != 1 || time.Until(created[0].Expires) > time.Hour {
+		t.Errorf("Unexpected default link %+v", created[0].shareLink)
+	}
+	if created[1].Role != RoleReadWrite || created[1].MaxUses != 0 || time.Until(created[1].Expires) > time.Minute {
+		t.Errorf("Unexpected read-write link %+v", created[1].shareLink)
+	}
+
+	resp, _ := adminRequest(t, server, "DELETE", "links/"+created[0].ID, "Bearer admin-token", "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected the link to be revoked, got %d", resp.StatusCode)
+	}
+	resp, _ = adminRequest(t, server, "DELETE", "links/"+created[0].ID, "Bearer admin-token", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the revoked link to be gone, got %d", resp.StatusCode)
+	}
+	links := app.links.list()
+	if len(links) != 1 || links[0].ID != created[1].ID {
+		t.Errorf("Expected only the read-write link, got %v", links)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	app, server := adminTestServer(t)
+
+	sessionID, err := connectAs(t, app, server, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, data := adminRequest(t, server, "GET", "sessions", "Bearer admin-token", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the sessions, got %d", resp.StatusCode)
+	}
+	var sessions []sessionInfo
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != sessionID || len(sessions[0].Clients) != 1 || sessions[0].Clients[0].User != "alice" {
+		t.Fatalf("Expected the session of alice, got %+v", sessions)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"get session", "GET", "sessions/" + sessionID, "", http.StatusMethodNotAllowed},
+		{"get signal", "GET", "sessions/" + sessionID + "/signal", "", http.StatusMethodNotAllowed},
+		{"unknown action", "POST", "sessions/" + sessionID + "/stop", "", http.StatusMethodNotAllowed},
+		{"malformed signal", "POST", "sessions/" + sessionID + "/signal", "{", http.StatusBadRequest},
+		{"unknown signal", "POST", "sessions/" + sessionID + "/signal", `{"signal": "FOO"}`, http.StatusBadRequest},
+		{"signal", "POST", "sessions/" + sessionID + "/signal", `{"signal": "WINCH"}`, http.StatusNoContent},
+		{"broadcast without message", "POST", "broadcast", `{}`, http.StatusBadRequest},
+		{"broadcast", "POST", "broadcast", `{"message": "maintenance"}`, http.StatusOK},
+		{"close session", "DELETE", "sessions/" + sessionID, "", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		resp, _ := adminRequest(t, server, test.method, test.path, "Bearer admin-token", test.body)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: expected %d, got %d", test.name, test.status, resp.StatusCode)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for app.findSession(sessionID) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("The closed session is still running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
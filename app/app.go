@@ -1,12 +1,14 @@
 package app
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/big"
@@ -14,18 +16,17 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/braintree/manners"
 	"github.com/elazarl/go-bindata-assetfs"
 	"github.com/gorilla/websocket"
-	"github.com/kr/pty"
 	"github.com/yudai/hcl"
 	"github.com/yudai/umutex"
 )
@@ -33,23 +34,48 @@ import (
 type InitMessage struct {
 	Arguments string `json:"Arguments,omitempty"`
 	AuthToken string `json:"AuthToken,omitempty"`
+	SessionID string `json:"SessionID,omitempty"`
 }
 
 type App struct {
-	command []string
+	factory Factory
 	options *Options
 
 	upgrader *websocket.Upgrader
-	server   *manners.GracefulServer
+	server   *manners.GracefulServer // nil when the App is mounted with Handler()
+	path     string
 
 	titleTemplate *template.Template
 
 	onceMutex *umutex.UnblockingMutex
 	timer     *time.Timer
 
+	sharedMutex *sync.Mutex
+	shared      *session
+
+	sessionsMutex *sync.Mutex
+	sessions      map[string]*session
+
+	credentials *credentialStore // nil when no credential file is given
+	tickets     *ticketIssuer
+	oidc        *oidcProvider // nil when OpenID Connect is disabled
+	crls        *crlStore     // nil when no CRL file is given
+	links       *shareLinkStore
+	metrics     *metrics
+	logger      *logger
+	audit       *auditLog // nil when the audit log is disabled
+	proxies     *trustedProxies
+
+	// nil when TLS is disabled or the App is mounted with Handler()
+	certificates *certificateStore
+
 	// clientContext writes concurrently
 	// Use atomic operations.
 	connections *int64
+
+	// set to 1 when the context given to Handler() is done
+	// Use atomic operations.
+	closed int32
 }
 
 type Options struct {
@@ -58,6 +84,12 @@ type Options struct {
 	PermitWrite         bool                   `hcl:"permit_write"`
 	EnableBasicAuth     bool                   `hcl:"enable_basic_auth"`
 	Credential          string                 `hcl:"credential"`
+	CredentialFile      string                 `hcl:"credential_file"`
+	TicketSecret        string                 `hcl:"ticket_secret"`
+	TicketLifetime      int                    `hcl:"ticket_lifetime"`
+	TicketBindIP        bool                   `hcl:"ticket_bind_ip"`
+	AllowedOrigins      []string               `hcl:"allowed_origins"`
+	RequireTicketCookie bool                   `hcl:"require_ticket_cookie"`
 	EnableRandomUrl     bool                   `hcl:"enable_random_url"`
 	FixedUrl            string                 `hcl:"enable_fixed_url"`
 	RandomUrlLength     int                    `hcl:"random_url_length"`
@@ -65,8 +97,20 @@ type Options struct {
 	EnableTLS           bool                   `hcl:"enable_tls"`
 	TLSCrtFile          string                 `hcl:"tls_crt_file"`
 	TLSKeyFile          string                 `hcl:"tls_key_file"`
+	TLSSelfSigned       bool                   `hcl:"tls_self_signed"`
+	TLSSaveSelfSigned   bool                   `hcl:"tls_save_self_signed"`
+	TLSMinVersion       string                 `hcl:"tls_min_version"`
+	TLSCipherSuites     []string               `hcl:"tls_cipher_suites"`
+	TLSCurves           []string               `hcl:"tls_curves"`
+	EnableHTTP2         bool                   `hcl:"enable_http2"`
 	EnableTLSClientAuth bool                   `hcl:"enable_tls_client_auth"`
 	TLSCACrtFile        string                 `hcl:"tls_ca_crt_file"`
+	TLSExtraCACrtFiles  []string               `hcl:"tls_extra_ca_crt_files"`
+	TLSClientAuthMode   string                 `hcl:"tls_client_auth_mode"`
+	TLSCRLFile          string                 `hcl:"tls_crl_file"`
+	TLSClientUserField  string                 `hcl:"tls_client_user_field"`
+	TLSClientAllowUsers []string               `hcl:"tls_client_allow_users"`
+	TLSClientDenyUsers  []string               `hcl:"tls_client_deny_users"`
 	TitleFormat         string                 `hcl:"title_format"`
 	EnableReconnect     bool                   `hcl:"enable_reconnect"`
 	ReconnectTime       int                    `hcl:"reconnect_time"`
@@ -79,6 +123,56 @@ type Options struct {
 	RawPreferences      map[string]interface{} `hcl:"preferences"`
 	Width               int                    `hcl:"width"`
 	Height              int                    `hcl:"height"`
+	EnableShared        bool                   `hcl:"enable_shared"`
+	SharedResizePolicy  string                 `hcl:"shared_resize_policy"`
+	SharedGracePeriod   int                    `hcl:"shared_grace_period"`
+	EnableReattach      bool                   `hcl:"enable_reattach"`
+	ReattachTime        int                    `hcl:"reattach_time"`
+	ScrollbackSize      int                    `hcl:"scrollback_size"`
+	EnableRecord        bool                   `hcl:"enable_record"`
+	RecordDir           string                 `hcl:"record_dir"`
+	RecordInput         bool                   `hcl:"record_input"`
+	EnablePlayback      bool                   `hcl:"enable_playback"`
+	PlaybackAuditors    []string               `hcl:"playback_auditors"`
+	UserRoles           map[string]string      `hcl:"user_roles"`
+	EnableOIDC          bool                   `hcl:"enable_oidc"`
+	OIDCIssuer          string                 `hcl:"oidc_issuer"`
+	OIDCClientID        string                 `hcl:"oidc_client_id"`
+	OIDCClientSecret    string                 `hcl:"oidc_client_secret"`
+	OIDCRedirectURL     string                 `hcl:"oidc_redirect_url"`
+	OIDCScopes          []string               `hcl:"oidc_scopes"`
+	OIDCUserClaim       string                 `hcl:"oidc_user_claim"`
+	OIDCGroupsClaim     string                 `hcl:"oidc_groups_claim"`
+	OIDCGroupRoles      map[string]string      `hcl:"oidc_group_roles"`
+	OIDCSessionTime     int                    `hcl:"oidc_session_time"`
+	AdminToken          string                 `hcl:"admin_token"`
+	EnableMetrics       bool                   `hcl:"enable_metrics"`
+	LogFormat           string                 `hcl:"log_format"`
+	LogLevel            string                 `hcl:"log_level"`
+	AuditLog            string                 `hcl:"audit_log"`
+	AuditMode           string                 `hcl:"audit_mode"`
+	AuditRecordNoEcho   bool                   `hcl:"audit_record_no_echo"`
+	LimitCPU            int                    `hcl:"limit_cpu"`
+	LimitMemory         int                    `hcl:"limit_memory"`
+	LimitPids           int                    `hcl:"limit_pids"`
+	CgroupParent        string                 `hcl:"cgroup_parent"`
+	RunAsUser           string                 `hcl:"run_as_user"`
+	RunAsIdentity       bool                   `hcl:"run_as_identity"`
+	RunAsUserMap        map[string]string      `hcl:"run_as_user_map"`
+	UnixSocket          string                 `hcl:"unix_socket"`
+	UnixSocketMode      string                 `hcl:"unix_socket_mode"`
+	UnixSocketGroup     string                 `hcl:"unix_socket_group"`
+	TrustedProxies      []string               `hcl:"trusted_proxies"`
+	ProxyProtocol       bool                   `hcl:"proxy_protocol"`
+	ExternalURL         string                 `hcl:"external_url"`
+	EnableSandbox       bool                   `hcl:"enable_sandbox"`
+	SandboxReadOnlyRoot bool                   `hcl:"sandbox_read_only_root"`
+	SandboxTmpfsHome    bool                   `hcl:"sandbox_tmpfs_home"`
+	SandboxNoNetwork    bool                   `hcl:"sandbox_no_network"`
+
+	// LogOutput is where logs are written, the standard error when nil.
+	// Embedders set it to collect the logs of the App.
+	LogOutput io.Writer `hcl:"-"`
 }
 
 var Version = "1.0.0"
@@ -89,6 +183,12 @@ var DefaultOptions = Options{
 	PermitWrite:         false,
 	EnableBasicAuth:     false,
 	Credential:          "",
+	CredentialFile:      "",
+	TicketSecret:        "",
+	TicketLifetime:      60,
+	TicketBindIP:        false,
+	AllowedOrigins:      []string{},
+	RequireTicketCookie: false,
 	EnableRandomUrl:     false,
 	FixedUrl:            "/OneAPM/ServerWebConsole",
 	RandomUrlLength:     8,
@@ -96,8 +196,20 @@ var DefaultOptions = Options{
 	EnableTLS:           false,
 	TLSCrtFile:          "~/.gotty.crt",
 	TLSKeyFile:          "~/.gotty.key",
+	TLSSelfSigned:       false,
+	TLSSaveSelfSigned:   false,
+	TLSMinVersion:       "1.2",
+	TLSCipherSuites:     []string{},
+	TLSCurves:           []string{},
+	EnableHTTP2:         false,
 	EnableTLSClientAuth: false,
 	TLSCACrtFile:        "~/.gotty.ca.crt",
+	TLSExtraCACrtFiles:  []string{},
+	TLSClientAuthMode:   TLSClientAuthRequire,
+	TLSCRLFile:          "",
+	TLSClientUserField:  CertUserFieldCN,
+	TLSClientAllowUsers: []string{},
+	TLSClientDenyUsers:  []string{},
 	TitleFormat:         "GoTTY - {{ .Command }} ({{ .Hostname }})",
 	EnableReconnect:     false,
 	ReconnectTime:       10,
@@ -107,18 +219,136 @@ var DefaultOptions = Options{
 	Preferences:         HtermPrefernces{},
 	Width:               0,
 	Height:              0,
+	EnableShared:        false,
+	SharedResizePolicy:  ResizePolicySmallest,
+	SharedGracePeriod:   0,
+	EnableReattach:      false,
+	ReattachTime:        60,
+	ScrollbackSize:      65536,
+	EnableRecord:        false,
+	RecordDir:           "~/.gotty.records",
+	RecordInput:         false,
+	EnablePlayback:      false,
+	PlaybackAuditors:    []string{},
+	EnableOIDC:          false,
+	OIDCIssuer:          "",
+	OIDCClientID:        "",
+	OIDCClientSecret:    "",
+	OIDCRedirectURL:     "",
+	OIDCScopes:          []string{"openid", "profile", "email"},
+	OIDCUserClaim:       "preferred_username",
+	OIDCGroupsClaim:     "groups",
+	OIDCSessionTime:     43200,
+	AdminToken:          "",
+	EnableMetrics:       false,
+	LogFormat:           LogFormatText,
+	LogLevel:            "info",
+	AuditLog:            "",
+	AuditMode:           AuditModeRaw,
+	AuditRecordNoEcho:   false,
+	LimitCPU:            0,
+	LimitMemory:         0,
+	LimitPids:           0,
+	CgroupParent:        "",
+	RunAsUser:           "",
+	RunAsIdentity:       false,
+	RunAsUserMap:        map[string]string{},
+	UnixSocket:          "",
+	UnixSocketMode:      "0660",
+	UnixSocketGroup:     "",
+	TrustedProxies:      []string{},
+	ProxyProtocol:       false,
+	ExternalURL:         "",
+	EnableSandbox:       false,
+	SandboxReadOnlyRoot: true,
+	SandboxTmpfsHome:    true,
+	SandboxNoNetwork:    false,
 }
 
+// New creates an App running the command locally for each session.
 func New(command []string, options *Options) (*App, error) {
+	factory := NewLocalCommandFactory(command, syscall.Signal(options.CloseSignal))
+	factory.Limits = &ResourceLimits{
+		CPU:    options.LimitCPU,
+		Memory: options.LimitMemory,
+		Pids:   options.LimitPids,
+	}
+	factory.CgroupParent = options.CgroupParent
+	factory.RunAsUser = options.RunAsUser
+	factory.RunAsIdentity = options.RunAsIdentity
+	factory.RunAsUserMap = options.RunAsUserMap
+	if options.EnableSandbox {
+		factory.Sandbox = &SandboxConfig{
+			ReadOnlyRoot:   options.SandboxReadOnlyRoot,
+			TmpfsHome:      options.SandboxTmpfsHome,
+			IsolateNetwork: options.SandboxNoNetwork,
+		}
+	}
+	return NewWithFactory(factory, options)
+}
+
+// NewWithFactory creates an App serving slaves created by the factory.
+func NewWithFactory(factory Factory, options *Options) (*App, error) {
 	titleTemplate, err := template.New("title").Parse(options.TitleFormat)
 	if err != nil {
 		return nil, errors.New("Title format string syntax error")
 	}
 
+	var logOutput io.Writer = os.Stderr
+	if options.LogOutput != nil {
+		logOutput = options.LogOutput
+	}
+	logger, err := newLogger(logOutput, options.LogFormat, options.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	if local, ok := factory.(*LocalCommandFactory); ok {
+		local.logger = logger
+	}
+
+	var credentials *credentialStore
+	if options.CredentialFile != "" {
+		credentials, err = newCredentialStore(options.CredentialFile, logger)
+		if err != nil {
+			return nil, errors.New("Failed to load credential file: " + err.Error())
+		}
+	}
+
+	var crls *crlStore
+	if options.EnableTLSClientAuth && options.TLSCRLFile != "" {
+		crls, err = newCRLStore(options.TLSCRLFile, logger)
+		if err != nil {
+			return nil, errors.New("Failed to load CRL file: " + err.Error())
+		}
+	}
+
+	var oidc *oidcProvider
+	if options.EnableOIDC {
+		oidc = newOIDCProvider(options.OIDCIssuer)
+	}
+
+	var audit *auditLog
+	if options.AuditLog != "" {
+		audit, err = newAuditLog(options.AuditLog, options.AuditMode, !options.AuditRecordNoEcho, logger)
+		if err != nil {
+			return nil, errors.New("Failed to open audit log: " + err.Error())
+		}
+	}
+
+	proxies, err := newTrustedProxies(options.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	tickets, err := newTicketIssuer(options.TicketSecret, options.TicketLifetime, options.TicketBindIP)
+	if err != nil {
+		return nil, errors.New("Failed to create ticket secret: " + err.Error())
+	}
+
 	connections := int64(0)
 
-	return &App{
-		command: command,
+	app := &App{
+		factory: factory,
 		options: options,
 
 		upgrader: &websocket.Upgrader{
@@ -130,8 +360,26 @@ func New(command []string, options *Options) (*App, error) {
 		titleTemplate: titleTemplate,
 
 		onceMutex:   umutex.New(),
+		sharedMutex: &sync.Mutex{},
+
+		sessionsMutex: &sync.Mutex{},
+		sessions:      make(map[string]*session),
+
+		credentials: credentials,
+		tickets:     tickets,
+		oidc:        oidc,
+		crls:        crls,
+		links:       newShareLinkStore(),
+		metrics:     newMetrics(),
+		logger:      logger,
+		audit:       audit,
+		proxies:     proxies,
+
 		connections: &connections,
-	}, nil
+	}
+	app.upgrader.CheckOrigin = app.checkOrigin
+
+	return app, nil
 }
 
 func ApplyConfigFile(options *Options, filePath string) error {
@@ -158,18 +406,172 @@ func CheckConfig(options *Options) error {
 	if options.EnableTLSClientAuth && !options.EnableTLS {
 		return errors.New("TLS client authentication is enabled, but TLS is not enabled")
 	}
+	if options.TLSSelfSigned && !options.EnableTLS {
+		return errors.New("Self-signed certificate is enabled, but TLS is not enabled")
+	}
+	if options.TLSClientAuthMode != TLSClientAuthRequire && options.TLSClientAuthMode != TLSClientAuthRequest {
+		return errors.New("Unknown TLS client auth mode: " + options.TLSClientAuthMode)
+	}
+	if _, err := newTLSPolicy(options); err != nil {
+		return err
+	}
+	if options.EnableHTTP2 && !options.EnableTLS {
+		return errors.New("HTTP/2 is enabled, but TLS is not enabled")
+	}
+	if options.SharedResizePolicy != ResizePolicySmallest && options.SharedResizePolicy != ResizePolicyOwner {
+		return errors.New("Unknown shared resize policy: " + options.SharedResizePolicy)
+	}
+	switch options.TLSClientUserField {
+	case CertUserFieldCN, CertUserFieldEmail, CertUserFieldDNS, CertUserFieldURI:
+	default:
+		if _, err := parseOID(options.TLSClientUserField); err != nil {
+			return errors.New("Unknown TLS client user field: " + options.TLSClientUserField)
+		}
+	}
+	if options.LogFormat != LogFormatText && options.LogFormat != LogFormatJSON {
+		return errors.New("Unknown log format: " + options.LogFormat)
+	}
+	if _, err := parseLogLevel(options.LogLevel); err != nil {
+		return err
+	}
+	if options.AuditMode != AuditModeRaw && options.AuditMode != AuditModeLine {
+		return errors.New("Unknown audit mode: " + options.AuditMode)
+	}
+	if options.LimitCPU < 0 || options.LimitMemory < 0 || options.LimitPids < 0 {
+		return errors.New("Resource limits must not be negative")
+	}
+	if _, err := strconv.ParseUint(options.UnixSocketMode, 8, 32); err != nil {
+		return errors.New("Invalid Unix socket mode: " + options.UnixSocketMode)
+	}
+	if _, err := newTrustedProxies(options.TrustedProxies); err != nil {
+		return err
+	}
+	if options.ProxyProtocol && len(options.TrustedProxies) == 0 {
+		return errors.New("PROXY protocol requires trusted proxies")
+	}
+	if options.ExternalURL != "" {
+		u, err := url.Parse(options.ExternalURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("Invalid external URL: " + options.ExternalURL)
+		}
+	}
+	if options.TicketLifetime <= 0 {
+		return errors.New("Ticket lifetime must be positive")
+	}
+	for user, role := range options.UserRoles {
+		if role != RoleReadOnly && role != RoleReadWrite {
+			return errors.New("Unknown role for user " + user + ": " + role)
+		}
+	}
+	if !options.EnableSandbox {
+		// The read-only root and the tmpfs home are on by default, so changing them is an error as well
+		if options.SandboxNoNetwork {
+			return errors.New("Sandbox without network is enabled, but the sandbox is not enabled")
+		}
+		if options.SandboxReadOnlyRoot != DefaultOptions.SandboxReadOnlyRoot || options.SandboxTmpfsHome != DefaultOptions.SandboxTmpfsHome {
+			return errors.New("Sandbox read-only root or tmpfs home is configured, but the sandbox is not enabled")
+		}
+	}
+	if options.EnableShared && options.RunAsIdentity {
+		return errors.New("Shared command and running commands as authenticated users cannot be enabled at the same time")
+	}
+	if options.EnableOIDC {
+		if options.EnableBasicAuth {
+			return errors.New("OpenID Connect and basic authentication cannot be enabled at the same time")
+		}
+		if options.OIDCIssuer == "" || options.OIDCClientID == "" {
+			return errors.New("OpenID Connect is enabled, but the issuer or the client ID is not given")
+		}
+		for group, role := range options.OIDCGroupRoles {
+			if role != RoleReadOnly && role != RoleReadWrite {
+				return errors.New("Unknown role for group " + group + ": " + role)
+			}
+		}
+	}
 	return nil
 }
 
 func (app *App) Run() error {
 	if app.options.PermitWrite {
-		log.Printf("Permitting clients to write input to the PTY.")
+		app.logger.infof("Permitting clients to write input to the PTY.")
 	}
 
 	if app.options.Once {
-		log.Printf("Once option is provided, accepting only one client")
+		app.logger.infof("Once option is provided, accepting only one client")
 	}
 
+	if app.options.EnableShared {
+		app.logger.infof("Sharing a single command with all clients (resize policy: %s)", app.options.SharedResizePolicy)
+	}
+
+	if app.options.AuditLog != "" {
+		app.logger.infof("Writing input from clients to the audit log at %s (mode: %s)", app.options.AuditLog, app.options.AuditMode)
+	}
+
+	if app.options.EnableRecord {
+		app.logger.infof("Recording sessions to %s", ExpandHomeDir(app.options.RecordDir))
+	}
+
+	siteHandler := app.makeHandler()
+	endpoint := net.JoinHostPort(app.options.Address, app.options.Port)
+
+	app.logger.infof("Server is starting with %s", app.factory.Name())
+
+	server, err := app.makeServer(endpoint, &siteHandler)
+	if err != nil {
+		return errors.New("Failed to build server: " + err.Error())
+	}
+	app.server = manners.NewWithServer(
+		server,
+	)
+
+	if app.options.Timeout > 0 {
+		app.timer = time.NewTimer(time.Duration(app.options.Timeout) * time.Second)
+		go func() {
+			<-app.timer.C
+			app.Exit()
+		}()
+	}
+
+	listener, err := app.listen(endpoint)
+	if err != nil {
+		return err
+	}
+
+	if app.options.EnableTLS {
+		certificate, _ := app.certificates.getCertificate(nil)
+		app.logger.infof("TLS certificate SHA-256 fingerprint: %s", certificateFingerprint(certificate))
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	err = app.server.Serve(listener)
+	app.closeSessions()
+	if err != nil {
+		return err
+	}
+
+	app.logger.infof("Exiting...")
+
+	return nil
+}
+
+// Handler returns an http.Handler serving the terminal,
+// so that the App can be mounted on an existing HTTP server.
+// The handler serves the URL path given by the options.
+// When ctx is done, all sessions are closed and new connections are refused.
+func (app *App) Handler(ctx context.Context) http.Handler {
+	handler := app.makeHandler()
+
+	go func() {
+		<-ctx.Done()
+		atomic.StoreInt32(&app.closed, 1)
+		app.closeSessions()
+	}()
+
+	return handler
+}
+
+func (app *App) makeHandler() http.Handler {
 	path := ""
 	if len(app.options.FixedUrl) > 0 {
 		path += app.options.FixedUrl
@@ -178,7 +580,7 @@ func (app *App) Run() error {
 			path += "/" + generateRandomString(app.options.RandomUrlLength)
 		}
 	}
-	endpoint := net.JoinHostPort(app.options.Address, app.options.Port)
+	app.path = path
 
 	wsHandler := http.HandlerFunc(app.handleWS)
 	customIndexHandler := http.HandlerFunc(app.handleCustomIndex)
@@ -190,7 +592,7 @@ func (app *App) Run() error {
 	var siteMux = http.NewServeMux()
 
 	if app.options.IndexFile != "" {
-		log.Printf("Using index file at " + app.options.IndexFile)
+		app.logger.infof("Using index file at %s", app.options.IndexFile)
 		siteMux.Handle(path+"/", customIndexHandler)
 	} else {
 		siteMux.Handle(path+"/", http.StripPrefix(path+"/", staticHandler))
@@ -199,11 +601,29 @@ func (app *App) Run() error {
 	siteMux.Handle(path+"/js/", http.StripPrefix(path+"/", staticHandler))
 	siteMux.Handle(path+"/favicon.png", http.StripPrefix(path+"/", staticHandler))
 
+	if app.options.EnablePlayback {
+		app.logger.infof("Serving recordings at %s/playback/", path)
+		siteMux.Handle(path+"/playback/", http.StripPrefix(path+"/playback/", staticHandler))
+		siteMux.Handle(path+"/playback/auth_token.js", authTokenHandler)
+	}
+
 	siteHandler := http.Handler(siteMux)
+	authHandler := siteHandler
 
 	if app.options.EnableBasicAuth {
-		log.Printf("Using Basic Authentication")
-		siteHandler = wrapBasicAuth(siteHandler, app.options.Credential)
+		app.logger.infof("Using Basic Authentication")
+		authHandler = app.wrapBasicAuth(authHandler)
+	}
+
+	if app.options.EnableOIDC {
+		app.logger.infof("Using OpenID Connect with %s", app.options.OIDCIssuer)
+		authHandler = app.wrapOIDC(authHandler)
+	}
+
+	if app.options.AdminToken != "" {
+		siteHandler = app.wrapShareLinks(siteHandler, authHandler)
+	} else {
+		siteHandler = authHandler
 	}
 
 	siteHandler = wrapHeaders(siteHandler)
@@ -211,91 +631,51 @@ func (app *App) Run() error {
 	wsMux := http.NewServeMux()
 	wsMux.Handle("/", siteHandler)
 	wsMux.Handle(path+"/ws", wsHandler)
-	siteHandler = (http.Handler(wsMux))
-
-	siteHandler = wrapLogger(siteHandler)
-
-	scheme := "http"
-	if app.options.EnableTLS {
-		scheme = "https"
+	if app.options.EnablePlayback {
+		wsMux.Handle(path+"/playback/ws", http.HandlerFunc(app.handlePlaybackWS))
 	}
-	log.Printf(
-		"Server is starting with command: %s",
-		strings.Join(app.command, " "),
-	)
-	if app.options.Address != "" {
-		log.Printf(
-			"URL: %s",
-			(&url.URL{Scheme: scheme, Host: endpoint, Path: path + "/"}).String(),
-		)
-	} else {
-		for _, address := range listAddresses() {
-			log.Printf(
-				"URL: %s",
-				(&url.URL{
-					Scheme: scheme,
-					Host:   net.JoinHostPort(address, app.options.Port),
-					Path:   path + "/",
-				}).String(),
-			)
+	if app.options.EnableMetrics {
+		metricsHandler := http.Handler(http.HandlerFunc(app.handleMetrics))
+		if app.options.AdminToken != "" {
+			metricsHandler = app.wrapAdminAuth(metricsHandler)
 		}
+		app.logger.infof("Serving metrics at /metrics")
+		wsMux.Handle("/metrics", metricsHandler)
 	}
-
-	server, err := app.makeServer(endpoint, &siteHandler)
-	if err != nil {
-		return errors.New("Failed to build server: " + err.Error())
-	}
-	app.server = manners.NewWithServer(
-		server,
-	)
-
-	if app.options.Timeout > 0 {
-		app.timer = time.NewTimer(time.Duration(app.options.Timeout) * time.Second)
-		go func() {
-			<-app.timer.C
-			app.Exit()
-		}()
+	if app.options.AdminToken != "" {
+		app.logger.infof("Serving admin API at %s/api/", path)
+		wsMux.Handle(path+"/api/", app.makeAdminHandler())
 	}
+	siteHandler = (http.Handler(wsMux))
 
-	if app.options.EnableTLS {
-		crtFile := ExpandHomeDir(app.options.TLSCrtFile)
-		keyFile := ExpandHomeDir(app.options.TLSKeyFile)
-		log.Printf("TLS crt file: " + crtFile)
-		log.Printf("TLS key file: " + keyFile)
-
-		err = app.server.ListenAndServeTLS(crtFile, keyFile)
-	} else {
-		err = app.server.ListenAndServe()
-	}
-	if err != nil {
-		return err
-	}
-
-	log.Printf("Exiting...")
-
-	return nil
+	return app.wrapProxy(app.wrapLogger(siteHandler))
 }
 
 func (app *App) makeServer(addr string, handler *http.Handler) (*http.Server, error) {
 	server := &http.Server{
-		Addr:    addr,
-		Handler: *handler,
+		Addr:     addr,
+		Handler:  *handler,
+		ErrorLog: log.New(app.logger, "", 0),
 	}
 
-	if app.options.EnableTLSClientAuth {
-		caFile := ExpandHomeDir(app.options.TLSCACrtFile)
-		log.Printf("CA file: " + caFile)
-		caCert, err := ioutil.ReadFile(caFile)
-		if err != nil {
-			return nil, errors.New("Could not open CA crt file " + caFile)
+	if app.options.EnableTLS {
+		caFiles := []string{}
+		if app.options.EnableTLSClientAuth {
+			for _, caFile := range append([]string{app.options.TLSCACrtFile}, app.options.TLSExtraCACrtFiles...) {
+				caFile = ExpandHomeDir(caFile)
+				app.logger.infof("CA file: %s", caFile)
+				caFiles = append(caFiles, caFile)
+			}
 		}
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, errors.New("Could not parse CA crt file data in " + caFile)
+		certificates, err := app.newCertificateStore(caFiles)
+		if err != nil {
+			return nil, err
 		}
-		tlsConfig := &tls.Config{
-			ClientCAs:  caCertPool,
-			ClientAuth: tls.RequireAndVerifyClientCert,
+		app.certificates = certificates
+
+		tlsConfig, err := app.makeTLSConfig(certificates)
+		if err != nil {
+			return nil, err
 		}
 		server.TLSConfig = tlsConfig
 	}
@@ -304,141 +684,261 @@ func (app *App) makeServer(addr string, handler *http.Handler) (*http.Server, er
 }
 
 func (app *App) stopTimer() {
-	if app.options.Timeout > 0 {
+	if app.timer != nil {
 		app.timer.Stop()
 	}
 }
 
 func (app *App) restartTimer() {
-	if app.options.Timeout > 0 {
+	if app.timer != nil {
 		app.timer.Reset(time.Duration(app.options.Timeout) * time.Second)
 	}
 }
 
+// startRoutine and finishRoutine let the server wait for sessions
+// before exiting. They do nothing when the App is mounted with Handler().
+func (app *App) startRoutine() {
+	if app.server != nil {
+		app.server.StartRoutine()
+	}
+}
+
+func (app *App) finishRoutine() {
+	if app.server != nil {
+		app.server.FinishRoutine()
+	}
+}
+
 func (app *App) handleWS(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&app.closed) == 1 {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
 	app.stopTimer()
 
+	logger := app.logger.with("remote_addr", r.RemoteAddr)
+
 	connections := atomic.AddInt64(app.connections, 1)
 	if int64(app.options.MaxConnection) != 0 {
 		if connections >= int64(app.options.MaxConnection) {
-			log.Printf("Reached max connection: %d", app.options.MaxConnection)
+			logger.warnf("Reached max connection: %d", app.options.MaxConnection)
 			return
 		}
 	}
-	log.Printf("New client connected: %s", r.RemoteAddr)
+	logger.infof("New client connected")
 
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
 
-	conn, err := app.upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Print("Failed to upgrade connection: " + err.Error())
+	if err := app.checkTicketCookie(r); err != nil {
+		logger.warnf("%v", err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	_, stream, err := conn.ReadMessage()
+	conn, err := app.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Print("Failed to authenticate websocket connection")
-		conn.Close()
+		logger.warnf("Failed to upgrade connection: %v", err)
 		return
 	}
-	var init InitMessage
 
-	err = json.Unmarshal(stream, &init)
+	init, id, err := app.receiveInitMessage(r, conn)
 	if err != nil {
-		log.Printf("Failed to parse init message %v", err)
+		logger.warnf("%v", err)
 		conn.Close()
 		return
 	}
-	if init.AuthToken != app.options.Credential {
-		log.Print("Failed to authenticate websocket connection")
-		conn.Close()
-		return
-	}
-	argv := app.command[1:]
+	logger = logger.with("user", id.user)
+	setAccessUser(w, id.user)
+	logger.infof("Client authenticated as %s", id)
+
+	params := &SlaveParams{User: id.user, RemoteAddr: r.RemoteAddr}
 	if app.options.PermitArguments {
 		if init.Arguments == "" {
 			init.Arguments = "?"
 		}
 		query, err := url.Parse(init.Arguments)
 		if err != nil {
-			log.Print("Failed to parse arguments")
+			logger.warnf("Failed to parse arguments")
 			conn.Close()
 			return
 		}
-		params := query.Query()["arg"]
-		if len(params) != 0 {
-			argv = append(argv, params...)
-		}
+		params.Arguments = query.Query()["arg"]
 	}
 
-	app.server.StartRoutine()
+	app.startRoutine()
 
 	if app.options.Once {
 		if app.onceMutex.TryLock() { // no unlock required, it will die soon
-			log.Printf("Last client accepted, closing the listener.")
-			app.server.Close()
+			logger.infof("Last client accepted, closing the listener.")
+			app.Exit()
 		} else {
-			log.Printf("Server is already closing.")
+			logger.infof("Server is already closing.")
+			atomic.AddInt64(app.connections, -1)
+			app.finishRoutine()
 			conn.Close()
 			return
 		}
 	}
 
-	cmd := exec.Command(app.command[0], argv...)
-	ptyIo, err := pty.Start(cmd)
-	if err != nil {
-		log.Print("Failed to execute command")
-		return
+	var session *session
+	if app.options.EnableReattach && init.SessionID != "" {
+		session = app.findSession(init.SessionID)
+		if session != nil && session.user != id.user {
+			logger.with("session", session.id).warnf("Refused to reattach to a session of another user")
+			atomic.AddInt64(app.connections, -1)
+			app.finishRoutine()
+			conn.Close()
+			return
+		}
+		if session != nil {
+			logger.with("session", session.id).infof("Client reattached to session")
+		} else {
+			logger.infof("Session requested by client no longer exists")
+		}
 	}
-
-	if app.options.MaxConnection != 0 {
-		log.Printf("Command is running for client %s with PID %d (args=%q), connections: %d/%d",
-			r.RemoteAddr, cmd.Process.Pid, strings.Join(argv, " "), connections, app.options.MaxConnection)
-	} else {
-		log.Printf("Command is running for client %s with PID %d (args=%q), connections: %d",
-			r.RemoteAddr, cmd.Process.Pid, strings.Join(argv, " "), connections)
+	if session == nil {
+		if app.options.EnableShared {
+			session, err = app.sharedSession(params)
+		} else {
+			session, err = app.startSession(params)
+		}
+		if err != nil {
+			logger.errorf("Failed to execute command: %v", err)
+			atomic.AddInt64(app.connections, -1)
+			app.finishRoutine()
+			conn.Close()
+			return
+		}
 	}
 
 	context := &clientContext{
+		id:         generateRandomString(16),
+		connected:  time.Now(),
 		app:        app,
 		request:    r,
 		connection: conn,
-		command:    cmd,
-		pty:        ptyIo,
+		session:    session,
+		identity:   id,
 		writeMutex: &sync.Mutex{},
 	}
+	if app.audit != nil {
+		context.auditor = newInputAuditor(app.audit, context)
+	}
+	context.logger = session.logger.with(
+		"client", context.id,
+		"remote_addr", r.RemoteAddr,
+		"user", id.user,
+	)
+
+	command := session.slave.WindowTitleVariables()["Command"]
+	if app.options.MaxConnection != 0 {
+		context.logger.infof("Command is running for client (command=%q), connections: %d/%d",
+			command, connections, app.options.MaxConnection)
+	} else {
+		context.logger.infof("Command is running for client (command=%q), connections: %d",
+			command, connections)
+	}
 
 	context.goHandleClient()
 }
 
+// receiveInitMessage reads the first message from the client
+// and authenticates the connection with it.
+func (app *App) receiveInitMessage(r *http.Request, conn *websocket.Conn) (*InitMessage, *identity, error) {
+	_, stream, err := conn.ReadMessage()
+	if err != nil {
+		return nil, nil, errors.New("Failed to authenticate websocket connection")
+	}
+	var init InitMessage
+
+	err = json.Unmarshal(stream, &init)
+	if err != nil {
+		return nil, nil, errors.New("Failed to parse init message " + err.Error())
+	}
+	id, err := app.authenticate(r, &init)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &init, id, nil
+}
+
 func (app *App) handleCustomIndex(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, ExpandHomeDir(app.options.IndexFile))
 }
 
 func (app *App) handleAuthToken(w http.ResponseWriter, r *http.Request) {
+	if !app.checkScriptSite(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	id, _ := r.Context().Value(identityContextKey).(*identity)
+	secret, err := newTicketCookieSecret()
+	if err != nil {
+		app.logger.errorf("Failed to issue ticket: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	token, err := app.tickets.issue(r, id, secret)
+	if err != nil {
+		app.logger.errorf("Failed to issue ticket: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// The ticket is bound to the secret in the cookie, which is neither readable by scripts
+	// nor sent by cross-site pages, so that a leaked ticket cannot be used without it
+	// when require_ticket_cookie is enabled
+	http.SetCookie(w, &http.Cookie{
+		Name:     ticketCookieName,
+		Value:    secret,
+		Path:     app.publicPath(r, app.path+"/"),
+		MaxAge:   app.options.TicketLifetime,
+		Secure:   isSecure(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
 	w.Header().Set("Content-Type", "application/javascript")
-	w.Write([]byte("var gotty_auth_token = '" + app.options.Credential + "';"))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("var gotty_auth_token = '" + token + "';"))
 }
 
 func (app *App) Exit() (firstCall bool) {
 	if app.server != nil {
 		firstCall = app.server.Close()
 		if firstCall {
-			log.Printf("Received Exit command, waiting for all clients to close sessions...")
+			app.logger.infof("Received Exit command, waiting for all clients to close sessions...")
 		}
 		return firstCall
 	}
 	return true
 }
 
-func wrapLogger(handler http.Handler) http.Handler {
+func (app *App) wrapLogger(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		rw := &responseWrapper{w, 200}
+		start := time.Now()
+		rw := &responseWrapper{ResponseWriter: w, status: 200}
 		handler.ServeHTTP(rw, r)
-		log.Printf("%s %d %s %s", r.RemoteAddr, rw.status, r.Method, r.URL.Path)
+
+		user := rw.user
+		if user == "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			user = app.certificateUser(r.TLS.PeerCertificates[0])
+		}
+		app.logger.with(
+			"remote_addr", r.RemoteAddr,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.status,
+			"bytes", rw.bytes,
+			"latency", time.Since(start),
+			"user_agent", r.UserAgent(),
+			"user", user,
+		).infof("HTTP request")
 	})
 }
 
@@ -449,7 +949,7 @@ func wrapHeaders(handler http.Handler) http.Handler {
 	})
 }
 
-func wrapBasicAuth(handler http.Handler, credential string) http.Handler {
+func (app *App) wrapBasicAuth(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
 
@@ -465,17 +965,35 @@ func wrapBasicAuth(handler http.Handler, credential string) http.Handler {
 			return
 		}
 
-		if credential != string(payload) {
+		credential := strings.SplitN(string(payload), ":", 2)
+		if len(credential) != 2 || !app.checkCredential(credential[0], credential[1]) {
+			app.metrics.authFailed(authFailureBasicAuth)
 			w.Header().Set("WWW-Authenticate", `Basic realm="GoTTY"`)
 			http.Error(w, "authorization failed", http.StatusUnauthorized)
 			return
 		}
 
-		log.Printf("Basic Authentication Succeeded: %s", r.RemoteAddr)
-		handler.ServeHTTP(w, r)
+		app.logger.with("remote_addr", r.RemoteAddr, "user", credential[0]).debugf("Basic Authentication Succeeded")
+		id := &identity{user: credential[0], role: app.roleOf(credential[0], nil)}
+		handler.ServeHTTP(w, withIdentity(w, r, id))
 	})
 }
 
+// checkCredential checks the user and password with the credential
+// and the credential file.
+func (app *App) checkCredential(user string, password string) bool {
+	if app.options.Credential != "" {
+		given := []byte(user + ":" + password)
+		if subtle.ConstantTimeCompare(given, []byte(app.options.Credential)) == 1 {
+			return true
+		}
+	}
+	if app.credentials != nil {
+		return app.credentials.authenticate(user, password)
+	}
+	return false
+}
+
 func generateRandomString(length int) string {
 	const base = 36
 	size := big.NewInt(base)
@@ -487,6 +1005,36 @@ func generateRandomString(length int) string {
 	return string(n)
 }
 
+// logURLs logs the URLs of the server listening on the TCP endpoint.
+func (app *App) logURLs(endpoint string) {
+	if app.options.ExternalURL != "" {
+		app.logger.infof("URL: %s", strings.TrimSuffix(app.options.ExternalURL, "/")+app.path+"/")
+		return
+	}
+
+	scheme := "http"
+	if app.options.EnableTLS {
+		scheme = "https"
+	}
+	if app.options.Address != "" {
+		app.logger.infof(
+			"URL: %s",
+			(&url.URL{Scheme: scheme, Host: endpoint, Path: app.path + "/"}).String(),
+		)
+	} else {
+		for _, address := range listAddresses() {
+			app.logger.infof(
+				"URL: %s",
+				(&url.URL{
+					Scheme: scheme,
+					Host:   net.JoinHostPort(address, app.options.Port),
+					Path:   app.path + "/",
+				}).String(),
+			)
+		}
+	}
+}
+
 func listAddresses() (addresses []string) {
 	ifaces, _ := net.Interfaces()
 
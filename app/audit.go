@@ -0,0 +1,208 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	AuditModeRaw  = "raw"
+	AuditModeLine = "line"
+)
+
+// auditLog writes input from clients to a file or syslog.
+// Each record is a line of JSON with the identity of the client and the session.
+type auditLog struct {
+	mutex          *sync.Mutex
+	writer         io.WriteCloser
+	logger         *logger
+	lineMode       bool
+	suppressNoEcho bool
+}
+
+type auditRecord struct {
+	Time       string `json:"time"`
+	Session    string `json:"session"`
+	PID        int    `json:"pid,omitempty"`
+	Client     string `json:"client"`
+	RemoteAddr string `json:"remote_addr"`
+	User       string `json:"user,omitempty"`
+	Input      string `json:"input,omitempty"` // raw input in the raw mode
+	Line       string `json:"line,omitempty"`  // reconstructed line in the line mode
+	Suppressed bool   `json:"suppressed,omitempty"`
+}
+
+// newAuditLog opens the destination of audit records,
+// which is "syslog" for the local syslog, "syslog://<host>:<port>" for a remote syslog over UDP,
+// or the path of a file to append to.
+func newAuditLog(destination string, mode string, suppressNoEcho bool, logger *logger) (*auditLog, error) {
+	var writer io.WriteCloser
+	var err error
+	switch {
+	case destination == "syslog":
+		writer, err = syslog.New(syslog.LOG_INFO|syslog.LOG_AUTHPRIV, "gotty")
+	case strings.HasPrefix(destination, "syslog://"):
+		writer, err = syslog.Dial("udp", strings.TrimPrefix(destination, "syslog://"), syslog.LOG_INFO|syslog.LOG_AUTHPRIV, "gotty")
+	default:
+		writer, err = os.OpenFile(ExpandHomeDir(destination), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{
+		mutex:          &sync.Mutex{},
+		writer:         writer,
+		logger:         logger,
+		lineMode:       mode == AuditModeLine,
+		suppressNoEcho: suppressNoEcho,
+	}, nil
+}
+
+func (audit *auditLog) write(record *auditRecord) {
+	record.Time = time.Now().Format(time.RFC3339Nano)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	if _, err := audit.writer.Write(append(data, '\n')); err != nil {
+		audit.logger.errorf("Failed to write audit log: %v", err)
+	}
+}
+
+// inputAuditor records input from a client.
+// In the line mode, it reconstructs lines by applying editing keys to the input,
+// which is an approximation as completion and history of shells are not visible.
+type inputAuditor struct {
+	audit   *auditLog
+	context *clientContext
+
+	line       []rune
+	escape     int // state of the escape sequence being skipped
+	suppressed bool
+}
+
+const (
+	escapeNone = iota
+	escapeStart
+	escapeCSI
+)
+
+func newInputAuditor(audit *auditLog, context *clientContext) *inputAuditor {
+	return &inputAuditor{
+		audit:   audit,
+		context: context,
+	}
+}
+
+func (auditor *inputAuditor) input(data []byte) {
+	suppressed := auditor.audit.suppressNoEcho && !auditor.echo()
+
+	if !auditor.audit.lineMode {
+		record := auditor.record()
+		if suppressed {
+			record.Suppressed = true
+		} else {
+			record.Input = string(data)
+		}
+		auditor.audit.write(record)
+		return
+	}
+
+	if suppressed {
+		auditor.suppressed = true
+	}
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		auditor.inputRune(r)
+	}
+}
+
+func (auditor *inputAuditor) inputRune(r rune) {
+	switch auditor.escape {
+	case escapeStart:
+		if r == '[' || r == 'O' {
+			auditor.escape = escapeCSI
+		} else {
+			auditor.escape = escapeNone
+		}
+		return
+	case escapeCSI:
+		if r >= 0x40 && r <= 0x7e {
+			auditor.escape = escapeNone
+		}
+		return
+	}
+
+	switch r {
+	case '\r', '\n':
+		auditor.flush()
+	case 0x1b: // ESC
+		auditor.escape = escapeStart
+	case 0x7f, 0x08: // DEL, BS
+		if len(auditor.line) > 0 {
+			auditor.line = auditor.line[:len(auditor.line)-1]
+		}
+	case 0x03, 0x15: // ^C, ^U
+		auditor.line = auditor.line[:0]
+		auditor.suppressed = false
+	default:
+		if r >= 0x20 || r == '\t' {
+			auditor.line = append(auditor.line, r)
+		}
+	}
+}
+
+// flush writes the current line.
+func (auditor *inputAuditor) flush() {
+	if len(auditor.line) == 0 && !auditor.suppressed {
+		return
+	}
+
+	record := auditor.record()
+	if auditor.suppressed {
+		record.Suppressed = true
+	} else {
+		record.Line = string(auditor.line)
+	}
+	auditor.audit.write(record)
+
+	auditor.line = auditor.line[:0]
+	auditor.suppressed = false
+}
+
+func (auditor *inputAuditor) echo() bool {
+	reporter, ok := auditor.context.session.slave.(EchoReporter)
+	if !ok {
+		return true
+	}
+	echo, err := reporter.Echo()
+	if err != nil {
+		return true
+	}
+	return echo
+}
+
+func (auditor *inputAuditor) record() *auditRecord {
+	context := auditor.context
+	record := &auditRecord{
+		Session:    context.session.id,
+		Client:     context.id,
+		RemoteAddr: context.request.RemoteAddr,
+		User:       context.identity.user,
+	}
+	if process, ok := context.session.slave.(Process); ok {
+		record.PID = process.Pid()
+	}
+	return record
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificateStore keeps the certificate of the server and the CA certificates of clients
+// loaded from files. The files are reloaded when they are modified, so that
+// new connections use rotated certificates while existing connections are kept.
+type certificateStore struct {
+	crtFile string // empty when the certificate is not loaded from files
+	keyFile string
+	caFiles []string // empty without client certificate authentication
+	logger  *logger
+
+	mutex       *sync.Mutex
+	modTimes    map[string]time.Time
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+}
+
+// newCertificateStore creates a store of the certificate loaded from the files,
+// or of the given certificate which is never reloaded when crtFile is empty.
+// The CA certificates of clients are loaded from all of the CA files.
+func newCertificateStore(crtFile string, keyFile string, certificate *tls.Certificate, caFiles []string, logger *logger) (*certificateStore, error) {
+	store := &certificateStore{
+		crtFile:     crtFile,
+		keyFile:     keyFile,
+		caFiles:     caFiles,
+		logger:      logger,
+		mutex:       &sync.Mutex{},
+		certificate: certificate,
+	}
+	if err := store.reload(true); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *certificateStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := store.reload(false); err != nil {
+		store.logger.warnf("Failed to reload TLS certificate: %v", err)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.certificate, nil
+}
+
+// configForClient returns the config with the current CA certificates of clients.
+func (store *certificateStore) configForClient(config *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		if err := store.reload(false); err != nil {
+			store.logger.warnf("Failed to reload TLS CA certificates: %v", err)
+		}
+
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		clientConfig := config.Clone()
+		clientConfig.ClientCAs = store.clientCAs
+		return clientConfig, nil
+	}
+}
+
+// verifyClient verifies the certificate chain of a client with the current CA certificates.
+func (store *certificateStore) verifyClient(certificates []*x509.Certificate) ([][]*x509.Certificate, error) {
+	store.mutex.Lock()
+	roots := store.clientCAs
+	store.mutex.Unlock()
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	return certificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+// reload loads the files when they are modified, or always when force is true.
+// The current certificates are kept when any of the files fails to load,
+// such as while a certificate and its key are being replaced.
+func (store *certificateStore) reload(force bool) error {
+	files := []string{}
+	if store.crtFile != "" {
+		files = append(files, store.crtFile, store.keyFile)
+	}
+	files = append(files, store.caFiles...)
+
+	modTimes := map[string]time.Time{}
+	modified := force
+	store.mutex.Lock()
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			store.mutex.Unlock()
+			return err
+		}
+		modTimes[file] = info.ModTime()
+		if !info.ModTime().Equal(store.modTimes[file]) {
+			modified = true
+		}
+	}
+	certificate := store.certificate
+	store.mutex.Unlock()
+	if !modified {
+		return nil
+	}
+
+	if store.crtFile != "" {
+		loaded, err := tls.LoadX509KeyPair(store.crtFile, store.keyFile)
+		if err != nil {
+			return err
+		}
+		certificate = &loaded
+	}
+	var clientCAs *x509.CertPool
+	if len(store.caFiles) > 0 {
+		clientCAs = x509.NewCertPool()
+	}
+	for _, caFile := range store.caFiles {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return errors.New("Could not open CA crt file " + caFile)
+		}
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return errors.New("Could not parse CA crt file data in " + caFile)
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if store.modTimes != nil && store.crtFile != "" && certificateFingerprint(certificate) != certificateFingerprint(store.certificate) {
+		store.logger.infof("Reloaded TLS certificate, SHA-256 fingerprint: %s", certificateFingerprint(certificate))
+	}
+	for _, caFile := range store.caFiles {
+		if store.modTimes != nil && !modTimes[caFile].Equal(store.modTimes[caFile]) {
+			store.logger.infof("Reloaded CA file: %s", caFile)
+		}
+	}
+	store.certificate = certificate
+	store.clientCAs = clientCAs
+	store.modTimes = modTimes
+	return nil
+}
+
+// ReloadCertificates reloads the certificate of the server and the CA certificates of clients from the files.
+// Connections established before are not affected.
+func (app *App) ReloadCertificates() error {
+	if app.certificates == nil {
+		return nil
+	}
+	if err := app.certificates.reload(true); err != nil {
+		app.logger.errorf("Failed to reload TLS certificates: %v", err)
+		return err
+	}
+	app.logger.infof("Reloaded TLS certificates")
+	return nil
+}
+
+// newCertificateStore loads the certificate of the server from the crt and key files,
+// or generates a self-signed certificate when tls_self_signed is enabled.
+func (app *App) newCertificateStore(caFiles []string) (*certificateStore, error) {
+	if app.options.TLSSelfSigned {
+		certificate, err := app.selfSignedCertificate()
+		if err != nil {
+			return nil, err
+		}
+		return newCertificateStore("", "", certificate, caFiles, app.logger)
+	}
+
+	crtFile := ExpandHomeDir(app.options.TLSCrtFile)
+	keyFile := ExpandHomeDir(app.options.TLSKeyFile)
+	app.logger.infof("TLS crt file: %s", crtFile)
+	app.logger.infof("TLS key file: %s", keyFile)
+	return newCertificateStore(crtFile, keyFile, nil, caFiles, app.logger)
+}
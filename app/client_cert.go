@@ -0,0 +1,230 @@
+package app
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fields of client certificates identifying users
+const (
+	CertUserFieldCN    = "cn"    // common name of the subject
+	CertUserFieldEmail = "email" // first email address SAN
+	CertUserFieldDNS   = "dns"   // first DNS name SAN
+	CertUserFieldURI   = "uri"   // first URI SAN
+)
+
+// certificateUser returns the user of a client certificate
+// in the field given by tls_client_user_field,
+// which is one of the fields above or an OID of a subject attribute.
+func (app *App) certificateUser(cert *x509.Certificate) string {
+	switch app.options.TLSClientUserField {
+	case CertUserFieldCN:
+		return cert.Subject.CommonName
+	case CertUserFieldEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case CertUserFieldDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case CertUserFieldURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	default:
+		oid, err := parseOID(app.options.TLSClientUserField)
+		if err != nil {
+			return ""
+		}
+		for _, name := range cert.Subject.Names {
+			if name.Type.Equal(oid) {
+				if value, ok := name.Value.(string); ok {
+					return value
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.New("Malformed OID: " + s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, errors.New("Malformed OID: " + s)
+	}
+	return oid, nil
+}
+
+// verifyClientConnection is called on every TLS handshake, including resumed sessions
+// which skip the verification of certificates, so that certificates revoked or removed
+// from the CA files and users denied after the session was established are rejected.
+// It verifies the certificate with the current CAs again,
+// and rejects revoked certificates and users denied by the allow and deny lists.
+func (app *App) verifyClientConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		// Clients may connect without certificates in the request mode
+		if app.options.TLSClientAuthMode == TLSClientAuthRequest {
+			return nil
+		}
+		return errors.New("No client certificate")
+	}
+	chains, err := app.certificates.verifyClient(state.PeerCertificates)
+	if err != nil {
+		app.logger.warnf("Rejected client certificate of %s: %v", state.PeerCertificates[0].Subject.CommonName, err)
+		app.metrics.authFailed(authFailureClientCertificate)
+		return err
+	}
+	chain := chains[0]
+
+	if app.crls != nil {
+		for i := 0; i+1 < len(chain); i++ {
+			if err := app.crls.check(chain[i], chain[i+1]); err != nil {
+				app.logger.warnf("Rejected client certificate of %s: %v", chain[0].Subject.CommonName, err)
+				app.metrics.authFailed(authFailureClientCertificate)
+				return err
+			}
+		}
+	}
+
+	user := app.certificateUser(chain[0])
+	for _, denied := range app.options.TLSClientDenyUsers {
+		if user == denied {
+			app.logger.warnf("Rejected client certificate of denied user %s", user)
+			app.metrics.authFailed(authFailureClientCertificate)
+			return errors.New("User denied: " + user)
+		}
+	}
+	if len(app.options.TLSClientAllowUsers) > 0 {
+		for _, allowed := range app.options.TLSClientAllowUsers {
+			if user == allowed {
+				return nil
+			}
+		}
+		app.logger.warnf("Rejected client certificate of user %s not allowed", user)
+		app.metrics.authFailed(authFailureClientCertificate)
+		return errors.New("User not allowed: " + user)
+	}
+	return nil
+}
+
+// crlStore keeps certificate revocation lists loaded from a file.
+// The file holds PEM encoded CRLs or a DER encoded CRL,
+// and is reloaded when it is modified.
+type crlStore struct {
+	path   string
+	logger *logger
+
+	mutex   *sync.Mutex
+	modTime time.Time
+	lists   []*x509.RevocationList
+}
+
+func newCRLStore(path string, logger *logger) (*crlStore, error) {
+	store := &crlStore{
+		path:   ExpandHomeDir(path),
+		logger: logger,
+		mutex:  &sync.Mutex{},
+	}
+	if err := store.reloadIfModified(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// check returns an error when the certificate is revoked by its issuer.
+func (store *crlStore) check(cert *x509.Certificate, issuer *x509.Certificate) error {
+	if err := store.reloadIfModified(); err != nil {
+		store.logger.warnf("Failed to reload CRL file: %v", err)
+	}
+
+	store.mutex.Lock()
+	lists := store.lists
+	store.mutex.Unlock()
+
+	for _, list := range lists {
+		if !bytes.Equal(list.RawIssuer, cert.RawIssuer) {
+			continue
+		}
+		if err := list.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		if !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate) {
+			store.logger.warnf("CRL of %s is out of date since %s", issuer.Subject.CommonName, list.NextUpdate)
+		}
+		for _, revoked := range list.RevokedCertificateEntries {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return errors.New("Certificate revoked: serial " + cert.SerialNumber.String())
+			}
+		}
+	}
+	return nil
+}
+
+func (store *crlStore) reloadIfModified() error {
+	info, err := os.Stat(store.path)
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.lists != nil && info.ModTime().Equal(store.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(store.path)
+	if err != nil {
+		return err
+	}
+
+	lists := []*x509.RevocationList{}
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "X509 CRL" {
+				continue
+			}
+			list, err := x509.ParseRevocationList(block.Bytes)
+			if err != nil {
+				return errors.New("Malformed CRL in " + store.path + ": " + err.Error())
+			}
+			lists = append(lists, list)
+		}
+	} else {
+		list, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return errors.New("Malformed CRL in " + store.path + ": " + err.Error())
+		}
+		lists = append(lists, list)
+	}
+
+	if store.lists != nil {
+		store.logger.infof("Reloaded CRL file: %s", store.path)
+	}
+	store.lists = lists
+	store.modTime = info.ModTime()
+	return nil
+}
@@ -4,27 +4,37 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
-	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"strings"
 	"sync"
 	"sync/atomic"
-	"syscall"
-	"unsafe"
+	"time"
 
 	"github.com/fatih/structs"
 	"github.com/gorilla/websocket"
 )
 
 type clientContext struct {
+	// bytes sent to and received from the client
+	// Use atomic operations.
+	bytesIn  int64
+	bytesOut int64
+
+	id         string
+	connected  time.Time
 	app        *App
 	request    *http.Request
 	connection *websocket.Conn
-	command    *exec.Cmd
-	pty        *os.File
+	session    *session
+	identity   *identity
 	writeMutex *sync.Mutex
+	logger     *logger
+	auditor    *inputAuditor // nil when the audit log is disabled
+
+	// window size requested by the client, guarded by session.mutex
+	columns uint16
+	rows    uint16
 }
 
 const (
@@ -39,6 +49,8 @@ const (
 	SetWindowTitle = '2'
 	SetPreferences = '3'
 	SetReconnect   = '4'
+	SetSessionID   = '5'
+	ShowOverlay    = '6'
 )
 
 type argResizeTerminal struct {
@@ -46,78 +58,60 @@ type argResizeTerminal struct {
 	Rows    float64
 }
 
+// ContextVars are the variables available in the title format.
 type ContextVars struct {
 	Command    string
 	Pid        int
 	Hostname   string
 	RemoteAddr string
+	User       string // empty when the client is anonymous
+
+	// Variables given by Slave.WindowTitleVariables, including Command and Pid
+	Slave map[string]interface{}
 }
 
 func (context *clientContext) goHandleClient() {
-	exit := make(chan bool, 2)
-
-	go func() {
-		defer func() { exit <- true }()
-
-		context.processSend()
-	}()
-
 	go func() {
-		defer func() { exit <- true }()
-
-		context.processReceive()
-	}()
-
-	go func() {
-		defer context.app.server.FinishRoutine()
+		defer context.app.finishRoutine()
 		defer func() {
 			connections := atomic.AddInt64(context.app.connections, -1)
 
 			if context.app.options.MaxConnection != 0 {
-				log.Printf("Connection closed: %s, connections: %d/%d",
-					context.request.RemoteAddr, connections, context.app.options.MaxConnection)
+				context.logger.infof("Connection closed, connections: %d/%d",
+					connections, context.app.options.MaxConnection)
 			} else {
-				log.Printf("Connection closed: %s, connections: %d",
-					context.request.RemoteAddr, connections)
+				context.logger.infof("Connection closed, connections: %d", connections)
 			}
 
 			if connections == 0 {
 				context.app.restartTimer()
 			}
 		}()
+		defer context.connection.Close()
 
-		<-exit
-		context.pty.Close()
-
-		// Even if the PTY has been closed,
-		// Read(0 in processSend() keeps blocking and the process doen't exit
-		context.command.Process.Signal(syscall.Signal(context.app.options.CloseSignal))
+		if err := context.sendInitialize(); err != nil {
+			context.logger.warnf("Failed to initialize client: %v", err)
+			context.session.detach(context)
+			return
+		}
+		if err := context.session.attach(context); err != nil {
+			context.logger.warnf("Failed to attach to session: %v", err)
+			return
+		}
+		defer context.session.detach(context)
 
-		context.command.Wait()
-		context.connection.Close()
+		context.processReceive()
 	}()
 }
 
-func (context *clientContext) processSend() {
-	if err := context.sendInitialize(); err != nil {
-		log.Printf(err.Error())
-		return
-	}
-
-	buf := make([]byte, 1024)
+func (context *clientContext) sendOutput(data []byte) error {
+	atomic.AddInt64(&context.bytesOut, int64(len(data)))
+	return context.write(outputMessage(data))
+}
 
-	for {
-		size, err := context.pty.Read(buf)
-		if err != nil {
-			log.Printf("Command exited for: %s", context.request.RemoteAddr)
-			return
-		}
-		safeMessage := base64.StdEncoding.EncodeToString([]byte(buf[:size]))
-		if err = context.write(append([]byte{Output}, []byte(safeMessage)...)); err != nil {
-			log.Printf(err.Error())
-			return
-		}
-	}
+func outputMessage(data []byte) []byte {
+	safeMessage := base64.StdEncoding.EncodeToString(data)
+	return append([]byte{Output}, []byte(safeMessage)...)
 }
 
 func (context *clientContext) write(data []byte) error {
@@ -127,13 +121,16 @@ func (context *clientContext) write(data []byte) error {
 }
 
 func (context *clientContext) sendInitialize() error {
+	slaveVars := context.session.slave.WindowTitleVariables()
 	hostname, _ := os.Hostname()
 	titleVars := ContextVars{
-		Command:    strings.Join(context.app.command, " "),
-		Pid:        context.command.Process.Pid,
 		Hostname:   hostname,
 		RemoteAddr: context.request.RemoteAddr,
+		User:       context.identity.user,
+		Slave:      slaveVars,
 	}
+	titleVars.Command, _ = slaveVars["Command"].(string)
+	titleVars.Pid, _ = slaveVars["Pid"].(int)
 
 	titleBuffer := new(bytes.Buffer)
 	if err := context.app.titleTemplate.Execute(titleBuffer, titleVars); err != nil {
@@ -143,16 +140,7 @@ func (context *clientContext) sendInitialize() error {
 		return err
 	}
 
-	prefStruct := structs.New(context.app.options.Preferences)
-	prefMap := prefStruct.Map()
-	htermPrefs := make(map[string]interface{})
-	for key, value := range prefMap {
-		rawKey := prefStruct.Field(key).Tag("hcl")
-		if _, ok := context.app.options.RawPreferences[rawKey]; ok {
-			htermPrefs[strings.Replace(rawKey, "_", "-", -1)] = value
-		}
-	}
-	prefs, err := json.Marshal(htermPrefs)
+	prefs, err := context.app.htermPreferences()
 	if err != nil {
 		return err
 	}
@@ -166,6 +154,11 @@ func (context *clientContext) sendInitialize() error {
 			return err
 		}
 	}
+	if context.app.options.EnableReattach {
+		if err := context.write(append([]byte{SetSessionID}, []byte(context.session.id)...)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -173,69 +166,64 @@ func (context *clientContext) processReceive() {
 	for {
 		_, data, err := context.connection.ReadMessage()
 		if err != nil {
-			log.Print(err.Error())
+			context.logger.debugf("Failed to read message: %v", err)
 			return
 		}
 		if len(data) == 0 {
-			log.Print("An error has occured")
+			context.logger.warnf("Received an empty message")
 			return
 		}
 
 		switch data[0] {
 		case Input:
-			if !context.app.options.PermitWrite {
+			atomic.AddInt64(&context.app.metrics.messagesInput, 1)
+			if !context.identity.canWrite() {
 				break
 			}
+			atomic.AddInt64(&context.bytesIn, int64(len(data)-1))
+			if context.auditor != nil {
+				context.auditor.input(data[1:])
+			}
 
-			_, err := context.pty.Write(data[1:])
+			err := context.session.write(data[1:])
 			if err != nil {
 				return
 			}
 
 		case Ping:
+			atomic.AddInt64(&context.app.metrics.messagesPing, 1)
 			if err := context.write([]byte{Pong}); err != nil {
-				log.Print(err.Error())
+				context.logger.debugf("Failed to send pong: %v", err)
 				return
 			}
 		case ResizeTerminal:
+			atomic.AddInt64(&context.app.metrics.messagesResize, 1)
 			var args argResizeTerminal
 			err = json.Unmarshal(data[1:], &args)
 			if err != nil {
-				log.Print("Malformed remote command")
+				context.logger.warnf("Malformed remote command")
 				return
 			}
 
-			rows := uint16(context.app.options.Height)
-			if rows == 0 {
-				rows = uint16(args.Rows)
-			}
-
-			columns := uint16(context.app.options.Width)
-			if columns == 0 {
-				columns = uint16(args.Columns)
-			}
-
-			window := struct {
-				row uint16
-				col uint16
-				x   uint16
-				y   uint16
-			}{
-				rows,
-				columns,
-				0,
-				0,
-			}
-			syscall.Syscall(
-				syscall.SYS_IOCTL,
-				context.pty.Fd(),
-				syscall.TIOCSWINSZ,
-				uintptr(unsafe.Pointer(&window)),
-			)
+			context.session.setWindowSize(context, uint16(args.Columns), uint16(args.Rows))
 
 		default:
-			log.Print("Unknown message type")
+			context.logger.warnf("Unknown message type")
 			return
 		}
 	}
 }
+
+// htermPreferences returns the hterm preferences given in the config file as JSON.
+func (app *App) htermPreferences() ([]byte, error) {
+	prefStruct := structs.New(app.options.Preferences)
+	prefMap := prefStruct.Map()
+	htermPrefs := make(map[string]interface{})
+	for key, value := range prefMap {
+		rawKey := prefStruct.Field(key).Tag("hcl")
+		if _, ok := app.options.RawPreferences[rawKey]; ok {
+			htermPrefs[strings.Replace(rawKey, "_", "-", -1)] = value
+		}
+	}
+	return json.Marshal(htermPrefs)
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// credentialStore authenticates users with a htpasswd file.
+// The file is reloaded when it is modified.
+//
+// Supported password formats are bcrypt ("$2y$", created by `htpasswd -B`),
+// SHA1 ("{SHA}", created by `htpasswd -s`) and plain text marked with "{PLAIN}".
+// Other formats, such as MD5 ("$apr1$", the default of `htpasswd`) and crypt,
+// are refused when the file is loaded, so that their hashes are never compared as plain text.
+type credentialStore struct {
+	path   string
+	logger *logger
+
+	mutex   *sync.Mutex
+	modTime time.Time
+	users   map[string]string // username to password hash
+}
+
+func newCredentialStore(path string, logger *logger) (*credentialStore, error) {
+	store := &credentialStore{
+		path:   ExpandHomeDir(path),
+		logger: logger,
+		mutex:  &sync.Mutex{},
+	}
+	if err := store.reloadIfModified(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *credentialStore) authenticate(user string, password string) bool {
+	if err := store.reloadIfModified(); err != nil {
+		store.logger.warnf("Failed to reload credential file: %v", err)
+	}
+
+	store.mutex.Lock()
+	hash, ok := store.users[user]
+	store.mutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(expected)) == 1
+	case strings.HasPrefix(hash, "{PLAIN}"):
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(hash, "{PLAIN}")), []byte(password)) == 1
+	default:
+		return false
+	}
+}
+
+// checkPasswordFormat returns an error when the password of the credential file is not in a supported format.
+func checkPasswordFormat(hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return errors.New("Malformed bcrypt hash: " + err.Error())
+		}
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SHA}"))
+		if err != nil || len(sum) != sha1.Size {
+			return errors.New("Malformed SHA1 hash")
+		}
+	case strings.HasPrefix(hash, "{PLAIN}"):
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		return errors.New("Unsupported MD5 hash, use bcrypt (htpasswd -B) instead")
+	default:
+		return errors.New("Unsupported password format, use bcrypt (htpasswd -B) or mark plain text passwords with {PLAIN}")
+	}
+	return nil
+}
+
+func (store *credentialStore) reloadIfModified() error {
+	info, err := os.Stat(store.path)
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users != nil && info.ModTime().Equal(store.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(store.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entry := strings.SplitN(line, ":", 2)
+		if len(entry) != 2 || entry[0] == "" {
+			return errors.New("Malformed line in credential file " + store.path)
+		}
+		if err := checkPasswordFormat(entry[1]); err != nil {
+			return errors.New("Invalid password of user " + entry[0] + " in credential file " + store.path + ": " + err.Error())
+		}
+		users[entry[0]] = entry[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if store.users != nil {
+		store.logger.infof("Reloaded credential file: %s", store.path)
+	}
+	store.users = users
+	store.modTime = info.ModTime()
+	return nil
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestCredentialStore(t *testing.T, content string) (*credentialStore, error) {
+	dir, err := ioutil.TempDir("", "gotty-credential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := newLogger(ioutil.Discard, LogFormatText, "info")
+	return newCredentialStore(path, logger)
+}
+
+func TestCredentialStoreFormats(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		hash   string
+	}{
+		{"bcrypt", string(bcryptHash)},
+		{"sha1", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="}, // htpasswd -nbs alice secret
+		{"plain", "{PLAIN}secret"},
+	}
+	for _, test := range tests {
+		store, err := newTestCredentialStore(t, "# users\nalice:"+test.hash+"\n")
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if !store.authenticate("alice", "secret") {
+			t.Errorf("%s: The password is refused", test.format)
+		}
+		if store.authenticate("alice", "wrong") {
+			t.Errorf("%s: A wrong password is accepted", test.format)
+		}
+		if store.authenticate("alice", test.hash) {
+			t.Errorf("%s: The hash is accepted as the password", test.format)
+		}
+		if store.authenticate("bob", "secret") {
+			t.Errorf("%s: An unknown user is accepted", test.format)
+		}
+	}
+}
+
+func TestCredentialStoreUnsupportedFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		hash   string
+	}{
+		{"apr1", "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/"},
+		{"md5-crypt", "$1$saltsalt$qjXMvbEw8oaL.CzflDugX."},
+		{"sha256-crypt", "$5$saltsalt$Jt/2ZqDPcJm6BWsh.TcGCh.VhhdC.fFaEAzUt9yRMs6"},
+		{"sha512-crypt", "$6$saltsalt$qFmFH.bQmmtXzyBY0s9v7Oicd2z4XSIecDzlB5KiA2/jctKu9YterLp8wwnSq.qc.eoxqOmSuNp2xS0ktL3nh/"},
+		{"des-crypt", "abJnggxhB/yWI"},
+		{"unmarked plain", "secret"},
+		{"malformed bcrypt", "$2y$05$short"},
+		{"malformed sha1", "{SHA}secret"},
+	}
+	for _, test := range tests {
+		if _, err := newTestCredentialStore(t, "alice:"+test.hash+"\n"); err == nil {
+			t.Errorf("%s: The credential file is accepted", test.format)
+		}
+	}
+}
+
+func TestCredentialStoreKeepsUsersOnInvalidReload(t *testing.T) {
+	store, err := newTestCredentialStore(t, "alice:{PLAIN}secret\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(store.path, []byte("alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := store.modTime.Add(time.Second)
+	os.Chtimes(store.path, future, future)
+
+	if !store.authenticate("alice", "secret") {
+		t.Error("The users are dropped by an invalid credential file")
+	}
+	if store.authenticate("alice", "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/") {
+		t.Error("The MD5 hash is accepted as the password")
+	}
+}
@@ -9,6 +9,8 @@ import (
 type responseWrapper struct {
 	http.ResponseWriter
 	status int
+	bytes  int
+	user   string // authenticated user shown in the access log
 }
 
 func (w *responseWrapper) WriteHeader(status int) {
@@ -16,8 +18,21 @@ func (w *responseWrapper) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
+func (w *responseWrapper) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += n
+	return n, err
+}
+
 func (w *responseWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hj, _ := w.ResponseWriter.(http.Hijacker)
 	w.status = http.StatusSwitchingProtocols
 	return hj.Hijack()
 }
+
+// setAccessUser records the user authenticated for the request in the access log.
+func setAccessUser(w http.ResponseWriter, user string) {
+	if rw, ok := w.(*responseWrapper); ok {
+		rw.user = user
+	}
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+const (
+	RoleReadOnly  = "read-only"
+	RoleReadWrite = "read-write"
+)
+
+type contextKey int
+
+// identityContextKey is the key of the identity authenticated by the HTTP layer
+// in the context of requests.
+const identityContextKey contextKey = 0
+
+// identity is the authenticated user of a connection and its role.
+type identity struct {
+	user string // empty when the user is anonymous
+	role string
+	link string // ID of the share link the user came with, if any
+}
+
+func (id *identity) canWrite() bool {
+	return id.role == RoleReadWrite
+}
+
+func (id *identity) String() string {
+	user := id.user
+	if user == "" {
+		user = "anonymous"
+	}
+	return user + " (" + id.role + ")"
+}
+
+// withIdentity returns the request carrying the identity authenticated by the HTTP layer
+// and records the user in the access log.
+func withIdentity(w http.ResponseWriter, r *http.Request, id *identity) *http.Request {
+	setAccessUser(w, id.user)
+	return r.WithContext(context.WithValue(r.Context(), identityContextKey, id))
+}
+
+// authenticate checks the init message of a websocket connection
+// and returns the identity of the client.
+func (app *App) authenticate(r *http.Request, init *InitMessage) (*identity, error) {
+	id, err := app.tickets.verify(r, init.AuthToken, app.options.RequireTicketCookie)
+	if err == errTicketCookieMismatch {
+		app.metrics.authFailed(authFailureTicketCookie)
+		return nil, errors.New("Failed to authenticate websocket connection: " + err.Error())
+	}
+	if err != nil {
+		app.metrics.authFailed(authFailureTicket)
+		return nil, errors.New("Failed to authenticate websocket connection: " + err.Error())
+	}
+	if id.link != "" && !app.links.valid(id.link) {
+		app.metrics.authFailed(authFailureShareLink)
+		return nil, errors.New("Failed to authenticate websocket connection: Share link revoked or expired")
+	}
+	if id.user == "" && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		id.user = app.certificateUser(r.TLS.PeerCertificates[0])
+	}
+	if id.role == "" {
+		id.role = app.roleOf(id.user, nil)
+	}
+
+	return id, nil
+}
+
+// roleOf returns the role of the user given in user_roles,
+// the strongest role of the groups given in oidc_group_roles,
+// or the default role given by permit_write.
+func (app *App) roleOf(user string, groups []string) string {
+	if role, ok := app.options.UserRoles[user]; ok && user != "" {
+		return role
+	}
+	groupRole := ""
+	for _, group := range groups {
+		switch app.options.OIDCGroupRoles[group] {
+		case RoleReadWrite:
+			return RoleReadWrite
+		case RoleReadOnly:
+			groupRole = RoleReadOnly
+		}
+	}
+	if groupRole != "" {
+		return groupRole
+	}
+	if app.options.PermitWrite {
+		return RoleReadWrite
+	}
+	return RoleReadOnly
+}
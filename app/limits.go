@@ -0,0 +1,27 @@
+package app
+
+const (
+	LimitMethodCgroup = "cgroup"
+	LimitMethodRlimit = "rlimit"
+)
+
+// rlimitInitEnv carries the command to the rlimit init process, which is gotty itself.
+const rlimitInitEnv = "GOTTY_RLIMIT_INIT"
+
+// ResourceLimits are the limits of resources applied to each command.
+// Zero means unlimited.
+type ResourceLimits struct {
+	CPU    int `json:"cpu,omitempty"`    // percentage of a CPU
+	Memory int `json:"memory,omitempty"` // megabytes
+	Pids   int `json:"pids,omitempty"`   // number of processes
+}
+
+func (limits *ResourceLimits) empty() bool {
+	return limits.CPU == 0 && limits.Memory == 0 && limits.Pids == 0
+}
+
+// cgroupManager creates a cgroup v2 for each command under the root directory.
+type cgroupManager struct {
+	root   string
+	logger *logger
+}
@@ -0,0 +1,277 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+// newCgroupManager prepares the parent cgroup of commands, given as a path under /sys/fs/cgroup.
+// When parent is empty, the cgroup of gotty itself is used and gotty moves into its child,
+// as cgroup v2 doesn't distribute resources to children of a cgroup having processes.
+func newCgroupManager(parent string, limits *ResourceLimits, logger *logger) (*cgroupManager, error) {
+	if _, err := os.Stat(filepath.Join(cgroupMountPoint, "cgroup.controllers")); err != nil {
+		return nil, errors.New("cgroup v2 is not mounted at " + cgroupMountPoint)
+	}
+
+	if parent == "" {
+		own, err := ownCgroup()
+		if err != nil {
+			return nil, err
+		}
+		parent = own
+
+		server := filepath.Join(cgroupMountPoint, own, "gotty")
+		if err := os.Mkdir(server, 0755); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		if err := writeCgroupFile(server, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			return nil, err
+		}
+	}
+	root := filepath.Join(cgroupMountPoint, parent)
+
+	available, err := ioutil.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+	controllers := []string{}
+	for controller, needed := range map[string]bool{
+		"cpu":    limits.CPU > 0,
+		"memory": limits.Memory > 0,
+		"pids":   limits.Pids > 0,
+	} {
+		if !needed {
+			continue
+		}
+		if !strings.Contains(" "+strings.TrimSpace(string(available))+" ", " "+controller+" ") {
+			return nil, errors.New("cgroup controller " + controller + " is not available in " + root)
+		}
+		controllers = append(controllers, "+"+controller)
+	}
+	if err := writeCgroupFile(root, "cgroup.subtree_control", strings.Join(controllers, " ")); err != nil {
+		return nil, err
+	}
+
+	return &cgroupManager{root: root, logger: logger}, nil
+}
+
+// ownCgroup returns the cgroup v2 path of the current process.
+func ownCgroup() (string, error) {
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), nil
+		}
+	}
+	return "", errors.New("No cgroup v2 found in /proc/self/cgroup")
+}
+
+// create creates a cgroup with the limits.
+// It returns the directory of the cgroup opened to start a command in it.
+func (manager *cgroupManager) create(limits *ResourceLimits) (*os.File, error) {
+	path := filepath.Join(manager.root, "session-"+generateRandomString(16))
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
+	}
+
+	settings := [][2]string{}
+	if limits.CPU > 0 {
+		settings = append(settings, [2]string{"cpu.max", strconv.Itoa(limits.CPU*1000) + " 100000"})
+	}
+	if limits.Memory > 0 {
+		settings = append(settings, [2]string{"memory.max", strconv.FormatInt(int64(limits.Memory)*1024*1024, 10)})
+	}
+	if limits.Pids > 0 {
+		settings = append(settings, [2]string{"pids.max", strconv.Itoa(limits.Pids)})
+	}
+	for _, setting := range settings {
+		if err := writeCgroupFile(path, setting[0], setting[1]); err != nil {
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return dir, nil
+}
+
+// remove kills processes left in the cgroup and removes it.
+func (manager *cgroupManager) remove(path string) {
+	writeCgroupFile(path, "cgroup.kill", "1")
+
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(path); err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	manager.logger.warnf("Failed to remove cgroup %s: %v", path, err)
+}
+
+func writeCgroupFile(dir string, name string, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+		return errors.New("Failed to write " + name + " of cgroup " + dir + ": " + err.Error())
+	}
+	return nil
+}
+
+// rlimitSpec is the command and the rlimits given to the rlimit init process.
+type rlimitSpec struct {
+	Path   string
+	Args   []string
+	Memory int
+}
+
+// prepareLimited sets up cgroups at the first call.
+// When cgroups are not available, it returns a command which starts gotty as the rlimit init process,
+// which sets the rlimits and executes the command, so that the command is limited from its start.
+func (factory *LocalCommandFactory) prepareLimited(cmd *exec.Cmd) (*exec.Cmd, error) {
+	factory.cgroupsOnce.Do(func() {
+		cgroups, err := newCgroupManager(factory.CgroupParent, factory.Limits, factory.logger)
+		if err != nil {
+			factory.logger.warnf("Failed to set up cgroups, limiting commands with rlimits: %v", err)
+			if factory.Limits.CPU > 0 {
+				factory.logger.warnf("CPU limit is not available without cgroups")
+			}
+			if factory.Limits.Pids > 0 {
+				factory.logger.warnf("Pids limit is not available without cgroups")
+			}
+			return
+		}
+		factory.cgroups = cgroups
+	})
+
+	limits := factory.rlimits()
+	if factory.cgroups != nil || limits.empty() {
+		return cmd, nil
+	}
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+
+	encoded, err := json.Marshal(&rlimitSpec{Path: cmd.Path, Args: cmd.Args, Memory: limits.Memory})
+	if err != nil {
+		return nil, err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	// Args are kept for the window title and the admin API
+	limited := &exec.Cmd{
+		Path:        "/proc/self/exe",
+		Args:        cmd.Args,
+		Env:         append(append([]string{}, env...), rlimitInitEnv+"="+string(encoded)),
+		Dir:         cmd.Dir,
+		SysProcAttr: cmd.SysProcAttr,
+	}
+	return limited, nil
+}
+
+// rlimits returns the limits enforced with rlimits when cgroups are not available.
+// RLIMIT_NPROC is not used for the pids limit, as it counts the processes of the user, not of the command.
+func (factory *LocalCommandFactory) rlimits() *ResourceLimits {
+	return &ResourceLimits{Memory: factory.Limits.Memory}
+}
+
+// startLimited starts the command prepared by prepareLimited,
+// in a new cgroup when cgroup v2 is available.
+func (factory *LocalCommandFactory) startLimited(cmd *exec.Cmd) (*LocalCommand, error) {
+	if factory.cgroups != nil {
+		dir, err := factory.cgroups.create(factory.Limits)
+		if err != nil {
+			return nil, err
+		}
+		defer dir.Close()
+
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+		lcmd, err := startLocalCommand(cmd, factory.closeSignal)
+		if err != nil {
+			factory.cgroups.remove(dir.Name())
+			return nil, err
+		}
+		lcmd.limits = factory.Limits
+		lcmd.limitMethod = LimitMethodCgroup
+		lcmd.cleanup = func() { factory.cgroups.remove(dir.Name()) }
+		return lcmd, nil
+	}
+
+	lcmd, err := startLocalCommand(cmd, factory.closeSignal)
+	if err != nil {
+		return nil, err
+	}
+	if limits := factory.rlimits(); !limits.empty() {
+		lcmd.limits = limits
+		lcmd.limitMethod = LimitMethodRlimit
+	}
+	return lcmd, nil
+}
+
+// runRlimitInit sets the rlimits and executes the command. It never returns.
+func runRlimitInit() {
+	spec := &rlimitSpec{}
+	if err := json.Unmarshal([]byte(os.Getenv(rlimitInitEnv)), spec); err != nil {
+		rlimitFail(errors.New("Invalid rlimit specification: " + err.Error()))
+	}
+	os.Unsetenv(rlimitInitEnv)
+
+	// Arguments are converted before the memory limit is set,
+	// as gotty itself may fail to allocate memory after that
+	path, err := syscall.BytePtrFromString(spec.Path)
+	if err != nil {
+		rlimitFail(err)
+	}
+	argv, err := syscall.SlicePtrFromStrings(spec.Args)
+	if err != nil {
+		rlimitFail(err)
+	}
+	envv, err := syscall.SlicePtrFromStrings(os.Environ())
+	if err != nil {
+		rlimitFail(err)
+	}
+
+	if spec.Memory > 0 {
+		limit := uint64(spec.Memory) * 1024 * 1024
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: limit, Max: limit}); err != nil {
+			rlimitFail(errors.New("Failed to set memory limit: " + err.Error()))
+		}
+	}
+
+	_, _, errno := syscall.RawSyscall(
+		syscall.SYS_EXECVE,
+		uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&argv[0])),
+		uintptr(unsafe.Pointer(&envv[0])),
+	)
+	rlimitFail(errors.New("Failed to execute " + spec.Path + ": " + errno.Error()))
+}
+
+func rlimitFail(err error) {
+	fmt.Fprintf(os.Stderr, "gotty: %v\r\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package app
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func (factory *LocalCommandFactory) prepareLimited(cmd *exec.Cmd) (*exec.Cmd, error) {
+	return nil, errors.New("Resource limits are only supported on Linux")
+}
+
+func (factory *LocalCommandFactory) startLimited(cmd *exec.Cmd) (*LocalCommand, error) {
+	return nil, errors.New("Resource limits are only supported on Linux")
+}
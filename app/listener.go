@@ -0,0 +1,211 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket activation.
+const systemdListenFDsStart = 3
+
+// listen opens the listener of the server, reading PROXY protocol headers when enabled.
+// The listener accepts connections from the sockets passed by systemd when activated by a socket unit,
+// the Unix domain socket when UnixSocket is set, or the TCP address otherwise.
+func (app *App) listen(endpoint string) (net.Listener, error) {
+	listener, err := app.openListener(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if app.options.ProxyProtocol {
+		app.logger.infof("Accepting PROXY protocol headers from trusted proxies")
+		listener = &proxyProtocolListener{Listener: listener, proxies: app.proxies, logger: app.logger}
+	}
+	return listener, nil
+}
+
+func (app *App) openListener(endpoint string) (net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		for _, listener := range listeners {
+			app.logger.infof("Listening on %s %s passed by systemd", listener.Addr().Network(), listener.Addr())
+		}
+		return newMultiListener(listeners), nil
+	}
+
+	if app.options.UnixSocket != "" {
+		return app.listenUnix(ExpandHomeDir(app.options.UnixSocket))
+	}
+
+	listener, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return nil, err
+	}
+	app.logURLs(endpoint)
+	return listener, nil
+}
+
+// listenUnix listens on the Unix domain socket at the path,
+// replacing a stale socket left by a previous process.
+func (app *App) listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("Refusing to replace " + path + " which is not a socket")
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New("Socket " + path + " is in use by another process")
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	mode, _ := strconv.ParseUint(app.options.UnixSocketMode, 8, 32)
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if app.options.UnixSocketGroup != "" {
+		group, err := user.LookupGroup(app.options.UnixSocketGroup)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		gid, _ := strconv.Atoi(group.Gid)
+		if err := os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	app.logger.infof("Listening on Unix socket %s (mode: %s)", path, app.options.UnixSocketMode)
+	return listener, nil
+}
+
+// systemdListeners returns the listeners passed by systemd with LISTEN_FDS,
+// or nothing when the process has not been activated by a socket unit.
+// The variables are removed so that commands don't inherit them.
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := []net.Listener{}
+	for i := 0; i < count; i++ {
+		fd := systemdListenFDsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, errors.New("Invalid socket " + name + " passed by systemd: " + err.Error())
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// multiListener accepts connections from multiple listeners.
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan net.Conn
+	errors    chan error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners []net.Listener) net.Listener {
+	if len(listeners) == 1 {
+		return listeners[0]
+	}
+
+	ml := &multiListener{
+		listeners: listeners,
+		conns:     make(chan net.Conn),
+		errors:    make(chan error),
+		closed:    make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go ml.acceptLoop(listener)
+	}
+	return ml
+}
+
+func (ml *multiListener) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case ml.errors <- err:
+			case <-ml.closed:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		select {
+		case ml.conns <- conn:
+		case <-ml.closed:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.conns:
+		return conn, nil
+	case err := <-ml.errors:
+		return nil, err
+	case <-ml.closed:
+		return nil, errors.New("Listener closed")
+	}
+}
+
+func (ml *multiListener) Close() error {
+	ml.closeOnce.Do(func() {
+		close(ml.closed)
+		for _, listener := range ml.listeners {
+			listener.Close()
+		}
+	})
+	return nil
+}
+
+// Addr returns the address of the first listener.
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
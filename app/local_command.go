@@ -0,0 +1,240 @@
+package app
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/kr/pty"
+)
+
+// LocalCommandFactory starts a local command on a PTY for each session.
+// Arguments from clients are appended to the command.
+// The user and the address of the client are given to the command
+// with the GOTTY_USER and GOTTY_REMOTE_ADDR environment variables.
+//
+// Commands run as the user of gotty by default. When RunAsUser or RunAsIdentity is set,
+// gotty needs to run as root or with CAP_SETUID and CAP_SETGID to switch users.
+// With Sandbox, gotty needs to run as root.
+// With Sandbox or Limits, gotty starts itself to set up commands,
+// so the program must call RunSandboxInit when IsSandboxInit returns true.
+type LocalCommandFactory struct {
+	// Limits of resources applied to each command, nil when unlimited
+	Limits *ResourceLimits
+	// Parent cgroup of commands under /sys/fs/cgroup, the cgroup of gotty when empty
+	CgroupParent string
+	// Unix user to run commands as, the user of gotty when empty
+	RunAsUser string
+	// Run commands as the unix user named after the authenticated user
+	RunAsIdentity bool
+	// Unix users of authenticated users for RunAsIdentity
+	RunAsUserMap map[string]string
+	// Namespaces each command runs in, nil to run commands in the namespaces of gotty
+	Sandbox *SandboxConfig
+
+	command     []string
+	closeSignal syscall.Signal
+	logger      *logger
+
+	cgroupsOnce sync.Once
+	cgroups     *cgroupManager // nil when cgroups are not available
+}
+
+func NewLocalCommandFactory(command []string, closeSignal syscall.Signal) *LocalCommandFactory {
+	logger, _ := newLogger(os.Stderr, LogFormatText, "info")
+	return &LocalCommandFactory{
+		command:     command,
+		closeSignal: closeSignal,
+		logger:      logger,
+	}
+}
+
+func (factory *LocalCommandFactory) Name() string {
+	return "command: " + strings.Join(factory.command, " ")
+}
+
+func (factory *LocalCommandFactory) New(params *SlaveParams) (Slave, error) {
+	argv := append(append([]string{}, factory.command[1:]...), params.Arguments...)
+	env := append(os.Environ(), "GOTTY_USER="+params.User, "GOTTY_REMOTE_ADDR="+params.RemoteAddr)
+
+	cmd := exec.Command(factory.command[0], argv...)
+	cmd.Env = env
+
+	u, err := factory.runAsUser(params.User)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		if err := runAs(cmd, u); err != nil {
+			return nil, err
+		}
+	}
+
+	limited := factory.Limits != nil && !factory.Limits.empty()
+	if limited {
+		cmd, err = factory.prepareLimited(cmd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if factory.Sandbox != nil {
+		cmd, err = factory.Sandbox.wrap(cmd, params.SessionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if limited {
+		return factory.startLimited(cmd)
+	}
+	return startLocalCommand(cmd, factory.closeSignal)
+}
+
+// LocalCommand is a command running on a PTY.
+type LocalCommand struct {
+	command     *exec.Cmd
+	pty         *os.File
+	closeSignal syscall.Signal
+
+	limits      *ResourceLimits // nil when unlimited
+	limitMethod string
+	cleanup     func() // called after the command exits, if not nil
+}
+
+// NewLocalCommand starts the command with the environment variables,
+// or the environment of the server when env is nil.
+func NewLocalCommand(command string, argv []string, env []string, closeSignal syscall.Signal) (*LocalCommand, error) {
+	cmd := exec.Command(command, argv...)
+	cmd.Env = env
+	return startLocalCommand(cmd, closeSignal)
+}
+
+// startLocalCommand starts the command on a new PTY,
+// keeping the SysProcAttr of the command given by the caller.
+func startLocalCommand(cmd *exec.Cmd, closeSignal syscall.Signal) (*LocalCommand, error) {
+	ptyIo, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	if err := cmd.Start(); err != nil {
+		ptyIo.Close()
+		return nil, err
+	}
+
+	return &LocalCommand{
+		command:     cmd,
+		pty:         ptyIo,
+		closeSignal: closeSignal,
+	}, nil
+}
+
+func (lcmd *LocalCommand) Read(p []byte) (int, error) {
+	return lcmd.pty.Read(p)
+}
+
+func (lcmd *LocalCommand) Write(p []byte) (int, error) {
+	return lcmd.pty.Write(p)
+}
+
+// Close closes the PTY and waits for the command to exit.
+func (lcmd *LocalCommand) Close() error {
+	lcmd.pty.Close()
+
+	// Even if the PTY has been closed,
+	// Read() keeps blocking and the process doesn't exit
+	lcmd.command.Process.Signal(lcmd.closeSignal)
+
+	lcmd.command.Wait()
+	if lcmd.cleanup != nil {
+		lcmd.cleanup()
+	}
+	return nil
+}
+
+func (lcmd *LocalCommand) WindowTitleVariables() map[string]interface{} {
+	return map[string]interface{}{
+		"Command": strings.Join(lcmd.command.Args, " "),
+		"Pid":     lcmd.command.Process.Pid,
+	}
+}
+
+func (lcmd *LocalCommand) Pid() int {
+	return lcmd.command.Process.Pid
+}
+
+func (lcmd *LocalCommand) Argv() []string {
+	return lcmd.command.Args
+}
+
+func (lcmd *LocalCommand) Signal(sig syscall.Signal) error {
+	return lcmd.command.Process.Signal(sig)
+}
+
+// Limits returns the limits of resources applied to the command and how they are enforced.
+func (lcmd *LocalCommand) Limits() (*ResourceLimits, string) {
+	return lcmd.limits, lcmd.limitMethod
+}
+
+func (lcmd *LocalCommand) ExitCode() int {
+	state := lcmd.command.ProcessState
+	if state == nil {
+		return -1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// Echo returns false when the terminal neither echoes input nor edits lines by itself,
+// which is the state where programs like sudo and passwd read passwords.
+// Line editors like readline disable the echo as well, but in the non-canonical mode.
+func (lcmd *LocalCommand) Echo() (bool, error) {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		lcmd.pty.Fd(),
+		ioctlGetTermios,
+		uintptr(unsafe.Pointer(&termios)),
+	)
+	if errno != 0 {
+		return false, errno
+	}
+	return termios.Lflag&syscall.ECHO != 0 || termios.Lflag&syscall.ICANON == 0, nil
+}
+
+func (lcmd *LocalCommand) ResizeTerminal(columns int, rows int) error {
+	window := struct {
+		row uint16
+		col uint16
+		x   uint16
+		y   uint16
+	}{
+		uint16(rows),
+		uint16(columns),
+		0,
+		0,
+	}
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		lcmd.pty.Fd(),
+		syscall.TIOCSWINSZ,
+		uintptr(unsafe.Pointer(&window)),
+	)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarn
+	logLevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (level logLevel) String() string {
+	return logLevelNames[level]
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.ToLower(name) == levelName {
+			return logLevel(level), nil
+		}
+	}
+	return 0, errors.New("Invalid log level: " + name)
+}
+
+// logger writes log lines in the text or JSON format.
+// Each line carries the fields of the logger, such as the session ID
+// and the remote address, so that lines about a session can be correlated.
+type logger struct {
+	output *logOutput
+	fields []logField
+}
+
+type logOutput struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+	json   bool
+	level  logLevel
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+func newLogger(writer io.Writer, format string, level string) (*logger, error) {
+	if format != LogFormatText && format != LogFormatJSON {
+		return nil, errors.New("Invalid log format: " + format)
+	}
+	minLevel, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logger{
+		output: &logOutput{
+			mutex:  &sync.Mutex{},
+			writer: writer,
+			json:   format == LogFormatJSON,
+			level:  minLevel,
+		},
+	}, nil
+}
+
+// with returns a logger adding the fields given as key and value pairs.
+// A field replaces the field of the same key.
+func (l *logger) with(keyvals ...interface{}) *logger {
+	fields := make([]logField, len(l.fields), len(l.fields)+len(keyvals)/2)
+	copy(fields, l.fields)
+
+next:
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		for j := range fields {
+			if fields[j].key == key {
+				fields[j].value = keyvals[i+1]
+				continue next
+			}
+		}
+		fields = append(fields, logField{key, keyvals[i+1]})
+	}
+
+	return &logger{output: l.output, fields: fields}
+}
+
+func (l *logger) debugf(format string, args ...interface{}) {
+	l.print(logLevelDebug, format, args...)
+}
+
+func (l *logger) infof(format string, args ...interface{}) {
+	l.print(logLevelInfo, format, args...)
+}
+
+func (l *logger) warnf(format string, args ...interface{}) {
+	l.print(logLevelWarn, format, args...)
+}
+
+func (l *logger) errorf(format string, args ...interface{}) {
+	l.print(logLevelError, format, args...)
+}
+
+// Write logs lines written by a log.Logger, such as errors of the HTTP server, at the info level.
+func (l *logger) Write(p []byte) (int, error) {
+	l.print(logLevelInfo, "%s", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+func (l *logger) print(level logLevel, format string, args ...interface{}) {
+	if level < l.output.level {
+		return
+	}
+	now := time.Now()
+	message := fmt.Sprintf(format, args...)
+
+	var line []byte
+	if l.output.json {
+		line = l.formatJSON(now, level, message)
+	} else {
+		line = l.formatText(now, level, message)
+	}
+
+	l.output.mutex.Lock()
+	defer l.output.mutex.Unlock()
+	l.output.writer.Write(line)
+}
+
+func (l *logger) formatText(now time.Time, level logLevel, message string) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteString(now.Format("2006/01/02 15:04:05 "))
+	buffer.WriteString(strings.ToUpper(level.String()))
+	buffer.WriteString(" ")
+	buffer.WriteString(message)
+	for _, field := range l.fields {
+		value := fmt.Sprint(fieldValue(field.value))
+		if value == "" || strings.ContainsAny(value, " =\"") {
+			value = strconv.Quote(value)
+		}
+		buffer.WriteString(" " + field.key + "=" + value)
+	}
+	buffer.WriteString("\n")
+	return buffer.Bytes()
+}
+
+func (l *logger) formatJSON(now time.Time, level logLevel, message string) []byte {
+	buffer := &bytes.Buffer{}
+	writeJSONField(buffer, "time", now.Format(time.RFC3339Nano))
+	writeJSONField(buffer, "level", level.String())
+	writeJSONField(buffer, "msg", message)
+	for _, field := range l.fields {
+		writeJSONField(buffer, field.key, fieldValue(field.value))
+	}
+	buffer.WriteString("}\n")
+	return buffer.Bytes()
+}
+
+func writeJSONField(buffer *bytes.Buffer, key string, value interface{}) {
+	if buffer.Len() == 0 {
+		buffer.WriteString("{")
+	} else {
+		buffer.WriteString(",")
+	}
+	encodedKey, _ := json.Marshal(key)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buffer.Write(encodedKey)
+	buffer.WriteString(":")
+	buffer.Write(encodedValue)
+}
+
+// fieldValue converts values which don't have a useful JSON encoding.
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.Seconds()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
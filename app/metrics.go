@@ -0,0 +1,135 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons of authentication failures
+const (
+	authFailureBasicAuth         = "basic_auth"
+	authFailureTicket            = "ticket"
+	authFailureTicketCookie      = "ticket_cookie"
+	authFailureOrigin            = "origin"
+	authFailureOIDC              = "oidc"
+	authFailureShareLink         = "share_link"
+	authFailureClientCertificate = "client_certificate"
+	authFailureAdminToken        = "admin_token"
+)
+
+// Buckets of the session duration histogram in seconds
+var sessionDurationBuckets = []float64{1, 10, 60, 300, 900, 3600, 14400, 86400}
+
+// metrics are exposed in the Prometheus text format.
+type metrics struct {
+	// Use atomic operations.
+	sessionsStarted int64
+	ptyBytesRead    int64
+	ptyBytesWritten int64
+	messagesInput   int64
+	messagesPing    int64
+	messagesResize  int64
+
+	mutex          *sync.Mutex
+	durationCounts []int64 // by bucket, not cumulative
+	durationSum    float64
+	authFailures   map[string]int64 // by reason
+	exitCodes      map[int]int64    // by exit code
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		mutex:          &sync.Mutex{},
+		durationCounts: make([]int64, len(sessionDurationBuckets)+1),
+		authFailures:   make(map[string]int64),
+		exitCodes:      make(map[int]int64),
+	}
+}
+
+func (m *metrics) authFailed(reason string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.authFailures[reason]++
+}
+
+// sessionClosed records a closed session.
+// exitCode is negative when the slave doesn't report it.
+func (m *metrics) sessionClosed(duration time.Duration, exitCode int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	seconds := duration.Seconds()
+	bucket := sort.SearchFloat64s(sessionDurationBuckets, seconds)
+	m.durationCounts[bucket]++
+	m.durationSum += seconds
+	if exitCode >= 0 {
+		m.exitCodes[exitCode]++
+	}
+}
+
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	m := app.metrics
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeMetric(w, "gotty_connections", "gauge", "Number of websocket connections counted against max_connection.")
+	fmt.Fprintf(w, "gotty_connections %d\n", atomic.LoadInt64(app.connections))
+
+	writeMetric(w, "gotty_sessions", "gauge", "Number of running sessions.")
+	fmt.Fprintf(w, "gotty_sessions %d\n", len(app.runningSessions()))
+
+	writeMetric(w, "gotty_sessions_started_total", "counter", "Number of sessions started.")
+	fmt.Fprintf(w, "gotty_sessions_started_total %d\n", atomic.LoadInt64(&m.sessionsStarted))
+
+	writeMetric(w, "gotty_pty_read_bytes_total", "counter", "Bytes read from commands.")
+	fmt.Fprintf(w, "gotty_pty_read_bytes_total %d\n", atomic.LoadInt64(&m.ptyBytesRead))
+
+	writeMetric(w, "gotty_pty_written_bytes_total", "counter", "Bytes written to commands.")
+	fmt.Fprintf(w, "gotty_pty_written_bytes_total %d\n", atomic.LoadInt64(&m.ptyBytesWritten))
+
+	writeMetric(w, "gotty_websocket_messages_total", "counter", "Websocket messages received from clients by type.")
+	fmt.Fprintf(w, "gotty_websocket_messages_total{type=\"input\"} %d\n", atomic.LoadInt64(&m.messagesInput))
+	fmt.Fprintf(w, "gotty_websocket_messages_total{type=\"ping\"} %d\n", atomic.LoadInt64(&m.messagesPing))
+	fmt.Fprintf(w, "gotty_websocket_messages_total{type=\"resize_terminal\"} %d\n", atomic.LoadInt64(&m.messagesResize))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeMetric(w, "gotty_session_duration_seconds", "histogram", "Duration of closed sessions.")
+	cumulative := int64(0)
+	for i, bound := range sessionDurationBuckets {
+		cumulative += m.durationCounts[i]
+		fmt.Fprintf(w, "gotty_session_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	cumulative += m.durationCounts[len(sessionDurationBuckets)]
+	fmt.Fprintf(w, "gotty_session_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "gotty_session_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "gotty_session_duration_seconds_count %d\n", cumulative)
+
+	writeMetric(w, "gotty_auth_failures_total", "counter", "Authentication failures by reason.")
+	reasons := make([]string, 0, len(m.authFailures))
+	for reason := range m.authFailures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "gotty_auth_failures_total{reason=%q} %d\n", reason, m.authFailures[reason])
+	}
+
+	writeMetric(w, "gotty_command_exits_total", "counter", "Exited commands by exit code, 128 + signal number when killed by a signal.")
+	codes := make([]int, 0, len(m.exitCodes))
+	for code := range m.exitCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "gotty_command_exits_total{code=\"%d\"} %d\n", code, m.exitCodes[code])
+	}
+}
+
+func writeMetric(w http.ResponseWriter, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsConnections(t *testing.T) {
+	options := DefaultOptions
+	options.EnableMetrics = true
+	options.EnableReattach = true
+	options.LogOutput = ioutil.Discard
+	app, err := New([]string{"cat"}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(app.Handler(ctx))
+	defer func() {
+		server.Close()
+		cancel()
+	}()
+
+	if _, err := connectAs(t, app, server, "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"gotty_connections 1\n", "gotty_sessions 1\n"} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("Expected %q in the metrics:\n%s", line, body)
+		}
+	}
+}
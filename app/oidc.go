@@ -0,0 +1,443 @@
+package app
+
+import (
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcSessionCookieName = "gotty_session"
+	oidcStateCookieName   = "gotty_oidc_state"
+	oidcLoginTime         = 10 * time.Minute
+	oidcClockSkew         = time.Minute
+)
+
+// oidcProvider is an OpenID Connect provider authenticating users
+// with the authorization code flow.
+// The configuration and the signing keys of the provider are fetched when first needed.
+type oidcProvider struct {
+	issuer string
+	client *http.Client
+
+	mutex     *sync.Mutex
+	discovery *oidcDiscovery            // nil until fetched
+	keys      map[string]*rsa.PublicKey // by key ID
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcState is kept in a cookie while the user logs in with the provider.
+type oidcState struct {
+	State   string `json:"s"`
+	Nonce   string `json:"n"`
+	Return  string `json:"r"` // URL to return to after the login
+	Expires int64  `json:"e"`
+}
+
+func newOIDCProvider(issuer string) *oidcProvider {
+	return &oidcProvider{
+		issuer: issuer,
+		client: &http.Client{Timeout: 10 * time.Second},
+		mutex:  &sync.Mutex{},
+	}
+}
+
+func (app *App) wrapOIDC(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case app.path + "/oidc/callback":
+			app.handleOIDCCallback(w, r)
+			return
+		case app.path + "/oidc/logout":
+			app.handleOIDCLogout(w, r)
+			return
+		}
+
+		if cookie, err := r.Cookie(oidcSessionCookieName); err == nil {
+			if id, err := app.tickets.verifySession(ticketKindSession, cookie.Value); err == nil {
+				handler.ServeHTTP(w, withIdentity(w, r, id))
+				return
+			}
+		}
+
+		if r.Method != "GET" {
+			http.Error(w, "authorization failed", http.StatusUnauthorized)
+			return
+		}
+		app.startOIDCLogin(w, r)
+	})
+}
+
+// startOIDCLogin redirects the user to the provider.
+func (app *App) startOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	config, err := app.oidc.config()
+	if err != nil {
+		app.logger.errorf("Failed to get OpenID Connect configuration: %v", err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+
+	state := oidcState{
+		State:   generateRandomString(32),
+		Nonce:   generateRandomString(32),
+		Return:  r.URL.RequestURI(),
+		Expires: time.Now().Add(oidcLoginTime).Unix(),
+	}
+	value, err := app.tickets.encode(signPurposeOIDCState, state)
+	if err != nil {
+		app.logger.errorf("Failed to start OpenID Connect login: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	app.setOIDCCookie(w, r, oidcStateCookieName, value, int(oidcLoginTime/time.Second))
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {app.options.OIDCClientID},
+		"redirect_uri":  {app.oidcRedirectURL(r)},
+		"scope":         {strings.Join(app.options.OIDCScopes, " ")},
+		"state":         {state.State},
+		"nonce":         {state.Nonce},
+	}
+	separator := "?"
+	if strings.Contains(config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, config.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+func (app *App) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var state oidcState
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil || app.tickets.decode(signPurposeOIDCState, cookie.Value, &state) != nil || time.Now().Unix() > state.Expires {
+		http.Error(w, "Login expired, reload the page to log in again", http.StatusBadRequest)
+		return
+	}
+	app.setOIDCCookie(w, r, oidcStateCookieName, "", -1)
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		app.logger.with("remote_addr", r.RemoteAddr).warnf("OpenID Connect login failed (%s: %s)", e, query.Get("error_description"))
+		app.metrics.authFailed(authFailureOIDC)
+		http.Error(w, "Login failed: "+e, http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		app.metrics.authFailed(authFailureOIDC)
+		http.Error(w, "Login state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := app.oidc.login(
+		query.Get("code"), app.oidcRedirectURL(r),
+		app.options.OIDCClientID, app.options.OIDCClientSecret, state.Nonce,
+	)
+	if err != nil {
+		app.logger.with("remote_addr", r.RemoteAddr).warnf("OpenID Connect login failed (%v)", err)
+		app.metrics.authFailed(authFailureOIDC)
+		http.Error(w, "Login failed", http.StatusForbidden)
+		return
+	}
+
+	user, _ := claims[app.options.OIDCUserClaim].(string)
+	if user == "" {
+		user, _ = claims["sub"].(string)
+	}
+	groups := claimStrings(claims[app.options.OIDCGroupsClaim])
+	id := &identity{user: user, role: app.roleOf(user, groups)}
+
+	session, err := app.tickets.issueSession(ticketKindSession, id, time.Duration(app.options.OIDCSessionTime)*time.Second)
+	if err != nil {
+		app.logger.errorf("Failed to issue login session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	app.setOIDCCookie(w, r, oidcSessionCookieName, session, app.options.OIDCSessionTime)
+	app.logger.with("remote_addr", r.RemoteAddr, "user", id.user).infof("OpenID Connect login succeeded as %s", id)
+
+	// Never redirect to another host
+	returnURL := state.Return
+	if !strings.HasPrefix(returnURL, "/") || strings.HasPrefix(returnURL, "//") {
+		returnURL = app.path + "/"
+	}
+	http.Redirect(w, r, app.publicPath(r, returnURL), http.StatusFound)
+}
+
+func (app *App) handleOIDCLogout(w http.ResponseWriter, r *http.Request) {
+	app.setOIDCCookie(w, r, oidcSessionCookieName, "", -1)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("Logged out\n"))
+}
+
+func (app *App) oidcRedirectURL(r *http.Request) string {
+	if app.options.OIDCRedirectURL != "" {
+		return app.options.OIDCRedirectURL
+	}
+	return app.absoluteURL(r, app.path+"/oidc/callback")
+}
+
+// setOIDCCookie sets a cookie used by the login.
+// The cookies must be sent with the redirect from the provider, so they cannot be strict.
+func (app *App) setOIDCCookie(w http.ResponseWriter, r *http.Request, name string, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     app.publicPath(r, app.path+"/"),
+		MaxAge:   maxAge,
+		Secure:   isSecure(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// config returns the configuration of the provider.
+func (p *oidcProvider) config() (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(p.issuer, "/") {
+		return nil, errors.New("Issuer mismatch in configuration: " + discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("Incomplete provider configuration")
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// login exchanges the authorization code for an ID token
+// and returns the verified claims in the token.
+func (p *oidcProvider) login(code string, redirectURL string, clientID string, clientSecret string, nonce string) (map[string]interface{}, error) {
+	config, err := p.config()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURL},
+	}
+	req, err := http.NewRequest("POST", config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, errors.New("Malformed token response: " + err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Token request failed: " + resp.Status + " " + token.Error + " " + token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("No ID token in token response")
+	}
+
+	claims, err := p.verify(token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != config.Issuer {
+		return nil, errors.New("Issuer mismatch in ID token: " + iss)
+	}
+	audience := false
+	for _, aud := range claimStrings(claims["aud"]) {
+		audience = audience || aud == clientID
+	}
+	if !audience {
+		return nil, errors.New("ID token is not issued for this client")
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Add(-oidcClockSkew).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("Expired ID token")
+	}
+	if n, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return nil, errors.New("Nonce mismatch in ID token")
+	}
+
+	return claims, nil
+}
+
+// verify verifies the signature of the ID token and returns its claims.
+func (p *oidcProvider) verify(idToken string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("Malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	var hash crypto.Hash
+	switch header.Alg {
+	case "RS256":
+		hash = crypto.SHA256
+	case "RS384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return nil, errors.New("Unsupported signing algorithm of ID token: " + header.Alg)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("Malformed ID token signature")
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, hash, hasher.Sum(nil), signature); err != nil {
+		return nil, errors.New("Invalid ID token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// key returns the signing key with the key ID.
+// The keys are fetched again when the key is unknown, as the provider may have rotated them.
+func (p *oidcProvider) key(kid string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	key, ok := p.keys[kid]
+	jwksURI := p.discovery.JWKSURI
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(jwksURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, errors.New("Unknown signing key of ID token: " + kid)
+	}
+	return key, nil
+}
+
+func (p *oidcProvider) getJSON(url string, value interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Failed to get " + url + ": " + resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(value)
+}
+
+func decodeJWTPart(part string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.New("Malformed ID token")
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return errors.New("Malformed ID token")
+	}
+	return nil
+}
+
+// claimStrings returns the strings in a claim holding a string or an array of strings.
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
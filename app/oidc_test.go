@@ -0,0 +1,265 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is an OpenID Connect provider issuing ID tokens for the claims given by tests.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex *sync.Mutex
+	codes map[string]map[string]interface{} // claims by authorization code
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key, mutex: &sync.Mutex{}, codes: map[string]map[string]interface{}{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if clientID, secret, _ := r.BasicAuth(); clientID != "gotty" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		issuer.mutex.Lock()
+		claims, ok := issuer.codes[r.FormValue("code")]
+		delete(issuer.codes, r.FormValue("code"))
+		issuer.mutex.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.sign(t, claims)})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+// authorize returns a code for an ID token with the claims and the nonce of the login.
+func (issuer *mockIssuer) authorize(nonce string, claims map[string]interface{}) string {
+	token := map[string]interface{}{
+		"iss":   issuer.URL,
+		"aud":   "gotty",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range claims {
+		token[name] = value
+	}
+
+	code := generateRandomString(16)
+	issuer.mutex.Lock()
+	issuer.codes[code] = token
+	issuer.mutex.Unlock()
+	return code
+}
+
+func (issuer *mockIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, issuer.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// oidcTestServer serves an App authenticating users with the issuer.
+func oidcTestServer(t *testing.T, issuer *mockIssuer) (*App, *httptest.Server) {
+	options := DefaultOptions
+	options.EnableOIDC = true
+	options.OIDCIssuer = issuer.URL
+	options.OIDCClientID = "gotty"
+	options.OIDCClientSecret = "secret"
+	options.OIDCGroupRoles = map[string]string{"admins": RoleReadWrite}
+
+	app, err := New([]string{"true"}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(app.Handler(ctx))
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+	return app, server
+}
+
+func noRedirectClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func getWithCookies(t *testing.T, client *http.Client, rawURL string, cookies ...*http.Cookie) *http.Response {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func findCookie(resp *http.Response, name string) *http.Cookie {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == name && cookie.Value != "" {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// startLogin opens the page and returns the state cookie and the authorization request.
+func startLogin(t *testing.T, client *http.Client, server *httptest.Server, issuer *mockIssuer) (*http.Cookie, url.Values) {
+	resp := getWithCookies(t, client, server.URL+"/OneAPM/ServerWebConsole/")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected a redirect to the issuer, got %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), issuer.URL+"/authorize?") {
+		t.Fatalf("Unexpected redirect to %s", resp.Header.Get("Location"))
+	}
+	state := findCookie(resp, oidcStateCookieName)
+	if state == nil {
+		t.Fatal("No state cookie")
+	}
+	return state, location.Query()
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+	app, server := oidcTestServer(t, issuer)
+	client := noRedirectClient()
+
+	state, query := startLogin(t, client, server, issuer)
+	if query.Get("client_id") != "gotty" || query.Get("redirect_uri") != server.URL+"/OneAPM/ServerWebConsole/oidc/callback" {
+		t.Fatalf("Unexpected authorization request: %v", query)
+	}
+
+	code := issuer.authorize(query.Get("nonce"), map[string]interface{}{
+		"sub":                "1234",
+		"preferred_username": "alice",
+		"groups":             []string{"staff", "admins"},
+	})
+	callback := server.URL + "/OneAPM/ServerWebConsole/oidc/callback?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	resp := getWithCookies(t, client, callback, state)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/OneAPM/ServerWebConsole/" {
+		t.Fatalf("Expected a redirect to the page, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	session := findCookie(resp, oidcSessionCookieName)
+	if session == nil {
+		t.Fatal("No session cookie")
+	}
+
+	id, err := app.tickets.verifySession(ticketKindSession, session.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.user != "alice" || id.role != RoleReadWrite {
+		t.Errorf("Expected alice with the read-write role, got %s with %s", id.user, id.role)
+	}
+
+	resp = getWithCookies(t, client, server.URL+"/OneAPM/ServerWebConsole/", session)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the page with the session, got %d", resp.StatusCode)
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+	_, server := oidcTestServer(t, issuer)
+	client := noRedirectClient()
+	callback := server.URL + "/OneAPM/ServerWebConsole/oidc/callback?"
+
+	// State mismatch
+	state, query := startLogin(t, client, server, issuer)
+	code := issuer.authorize(query.Get("nonce"), map[string]interface{}{"sub": "alice"})
+	resp := getWithCookies(t, client, callback+url.Values{"code": {code}, "state": {"forged"}}.Encode(), state)
+	if resp.StatusCode != http.StatusBadRequest || findCookie(resp, oidcSessionCookieName) != nil {
+		t.Errorf("Expected a state mismatch, got %d", resp.StatusCode)
+	}
+
+	// Nonce of another login
+	state, query = startLogin(t, client, server, issuer)
+	code = issuer.authorize("replayed", map[string]interface{}{"sub": "alice"})
+	resp = getWithCookies(t, client, callback+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), state)
+	if resp.StatusCode != http.StatusForbidden || findCookie(resp, oidcSessionCookieName) != nil {
+		t.Errorf("Expected a nonce mismatch, got %d", resp.StatusCode)
+	}
+
+	// No state cookie
+	_, query = startLogin(t, client, server, issuer)
+	code = issuer.authorize(query.Get("nonce"), map[string]interface{}{"sub": "alice"})
+	resp = getWithCookies(t, client, callback+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode())
+	if resp.StatusCode != http.StatusBadRequest || findCookie(resp, oidcSessionCookieName) != nil {
+		t.Errorf("Expected an expired login, got %d", resp.StatusCode)
+	}
+}
+
+func TestOIDCStateIsNotTicket(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+	app, server := oidcTestServer(t, issuer)
+
+	state, _ := startLogin(t, noRedirectClient(), server, issuer)
+	r := httptest.NewRequest("GET", "/OneAPM/ServerWebConsole/ws", nil)
+	if _, err := app.tickets.verify(r, state.Value, false); err == nil {
+		t.Error("The state cookie is accepted as a websocket ticket")
+	}
+	if _, err := app.tickets.verifySession(ticketKindSession, state.Value); err == nil {
+		t.Error("The state cookie is accepted as a login session")
+	}
+}
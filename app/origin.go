@@ -0,0 +1,89 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// checkOrigin protects websocket connections against cross-site websocket hijacking.
+// The origin of a browser must be one of allowed_origins, or the same origin
+// as the server when none is given. "*" allows any origin.
+// Requests without the Origin header come from non-browser clients and are allowed.
+func (app *App) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	return app.originAllowed(r, origin)
+}
+
+// originAllowed reports whether requests from the origin are allowed.
+func (app *App) originAllowed(r *http.Request, origin string) bool {
+	if len(app.options.AllowedOrigins) > 0 {
+		for _, allowed := range app.options.AllowedOrigins {
+			if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+				return true
+			}
+		}
+		app.logger.with("remote_addr", r.RemoteAddr).warnf("Origin %s is not allowed", origin)
+		app.metrics.authFailed(authFailureOrigin)
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		app.logger.with("remote_addr", r.RemoteAddr).warnf("Malformed origin %s", origin)
+		app.metrics.authFailed(authFailureOrigin)
+		return false
+	}
+	if !strings.EqualFold(u.Scheme, requestScheme(r)) || !strings.EqualFold(u.Host, r.Host) {
+		app.logger.with("remote_addr", r.RemoteAddr).warnf("Cross-origin request from %s", origin)
+		app.metrics.authFailed(authFailureOrigin)
+		return false
+	}
+	return true
+}
+
+// checkScriptSite protects auth_token.js against inclusion by pages of other sites,
+// which could read tickets issued with the credentials the browser sends automatically.
+// The site of the page is told by Sec-Fetch-Site, or by Origin or Referer in older browsers.
+// Requests without any of them come from non-browser clients and are allowed.
+func (app *App) checkScriptSite(r *http.Request) bool {
+	site := r.Header.Get("Sec-Fetch-Site")
+	if site == "same-origin" || site == "none" {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if u, err := url.Parse(r.Referer()); err == nil && u.Host != "" {
+			origin = u.Scheme + "://" + u.Host
+		}
+	}
+	if origin == "" {
+		if site == "" {
+			return true
+		}
+		app.logger.with("remote_addr", r.RemoteAddr).warnf("Request for tickets from a %s page", site)
+		app.metrics.authFailed(authFailureOrigin)
+		return false
+	}
+	return app.originAllowed(r, origin)
+}
+
+// checkTicketCookie checks the ticket cookie set along with auth_token.js
+// when require_ticket_cookie is enabled.
+// The ticket in the init message is verified to be bound to the cookie later.
+func (app *App) checkTicketCookie(r *http.Request) error {
+	if !app.options.RequireTicketCookie {
+		return nil
+	}
+
+	if _, err := r.Cookie(ticketCookieName); err != nil {
+		app.metrics.authFailed(authFailureTicketCookie)
+		return errors.New("No ticket cookie from client " + r.RemoteAddr)
+	}
+	return nil
+}
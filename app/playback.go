@@ -0,0 +1,466 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	playbackSeekStep = 5 // seconds
+	playbackMaxSpeed = 16
+)
+
+// recording is an asciicast v2 file loaded for playback.
+type recording struct {
+	header   asciicastHeader
+	events   []playbackEvent // output events in order
+	duration float64
+}
+
+type playbackEvent struct {
+	time float64
+	data string
+}
+
+// player streams a recording to a client with its original timing.
+// The client controls the playback with keystrokes.
+type player struct {
+	app        *App
+	name       string
+	recording  *recording
+	connection *websocket.Conn
+	writeMutex *sync.Mutex
+	keys       chan string
+	done       chan struct{}
+
+	next     int // index of the next event to send
+	paused   bool
+	speed    float64
+	base     float64   // position in seconds when the clock was last set
+	baseTime time.Time // when the clock was last set
+}
+
+func (app *App) handlePlaybackWS(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&app.closed) == 1 {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", 405)
+		return
+	}
+
+	logger := app.logger.with("remote_addr", r.RemoteAddr)
+
+	if err := app.checkTicketCookie(r); err != nil {
+		logger.warnf("%v", err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	conn, err := app.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.warnf("Failed to upgrade connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	init, id, err := app.receiveInitMessage(r, conn)
+	if err != nil {
+		logger.warnf("%v", err)
+		return
+	}
+	logger = logger.with("user", id.user)
+	setAccessUser(w, id.user)
+
+	app.startRoutine()
+	defer app.finishRoutine()
+
+	query, err := url.Parse(init.Arguments)
+	if err != nil {
+		logger.warnf("Failed to parse arguments")
+		return
+	}
+	name := query.Query().Get("recording")
+	if name == "" {
+		if err := app.sendRecordingList(conn, id); err != nil {
+			logger.warnf("Failed to send recording list: %v", err)
+		}
+		return
+	}
+
+	rec, err := app.loadRecording(name)
+	if err == nil && !app.canPlay(id, &rec.header) {
+		err = errors.New("Recording of another user")
+	}
+	if err != nil {
+		logger.warnf("Failed to load recording %q: %v", name, err)
+		conn.WriteMessage(websocket.TextMessage, outputMessage([]byte("Failed to load recording: "+name+"\r\n")))
+		return
+	}
+
+	logger.infof("Playing %s", name)
+	p := &player{
+		app:        app,
+		name:       name,
+		recording:  rec,
+		connection: conn,
+		writeMutex: &sync.Mutex{},
+		keys:       make(chan string),
+		done:       make(chan struct{}),
+		speed:      1,
+	}
+	if err := p.run(); err != nil {
+		logger.debugf("Playback stopped: %v", err)
+	}
+	logger.infof("Playback closed")
+}
+
+// canPlay reports whether the client can play a recording.
+// Users given in playback_auditors can play all recordings, and others only their own.
+// Clients coming with share links can't play recordings.
+func (app *App) canPlay(id *identity, header *asciicastHeader) bool {
+	if id.link != "" {
+		return false
+	}
+	for _, auditor := range app.options.PlaybackAuditors {
+		if id.user != "" && id.user == auditor {
+			return true
+		}
+	}
+	return id.user == header.Owner
+}
+
+// sendRecordingList sends the recordings the client can play.
+func (app *App) sendRecordingList(conn *websocket.Conn, id *identity) error {
+	dir := ExpandHomeDir(app.options.RecordDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	list := new(bytes.Buffer)
+	fmt.Fprintf(list, "Recordings in %s:\r\n\r\n", dir)
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".cast" {
+			continue
+		}
+		header, err := readRecordingHeader(filepath.Join(dir, file.Name()))
+		if err != nil || !app.canPlay(id, header) {
+			continue
+		}
+		fmt.Fprintf(list, "  %s  %8d bytes\r\n", file.Name(), file.Size())
+	}
+	list.WriteString("\r\nAdd ?recording=<name> to the URL to play a recording.\r\n")
+
+	return conn.WriteMessage(websocket.TextMessage, outputMessage(list.Bytes()))
+}
+
+func (app *App) loadRecording(name string) (*recording, error) {
+	if name != filepath.Base(name) || filepath.Ext(name) != ".cast" {
+		return nil, errors.New("Invalid recording name")
+	}
+
+	file, err := os.Open(filepath.Join(ExpandHomeDir(app.options.RecordDir), name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header, err := parseRecordingHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	rec := &recording{header: *header}
+
+	// Idle time is shortened to the limit specified in the header
+	var last, shift float64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var event []interface{}
+			if err := json.Unmarshal(line, &event); err != nil || len(event) != 3 {
+				return nil, errors.New("Malformed event")
+			}
+			eventTime, _ := event[0].(float64)
+			eventType, _ := event[1].(string)
+			data, _ := event[2].(string)
+
+			if limit := rec.header.IdleTimeLimit; limit > 0 && eventTime-last > limit {
+				shift += eventTime - last - limit
+			}
+			last = eventTime
+
+			if eventType == "o" {
+				rec.events = append(rec.events, playbackEvent{time: eventTime - shift, data: data})
+				rec.duration = eventTime - shift
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rec, nil
+}
+
+func readRecordingHeader(path string) (*asciicastHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseRecordingHeader(bufio.NewReader(file))
+}
+
+func parseRecordingHeader(reader *bufio.Reader) (*asciicastHeader, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	header := &asciicastHeader{}
+	if err := json.Unmarshal(line, header); err != nil {
+		return nil, errors.New("Malformed header: " + err.Error())
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("Unsupported asciicast version: %d", header.Version)
+	}
+	return header, nil
+}
+
+func (p *player) run() error {
+	prefs, err := p.app.htermPreferences()
+	if err != nil {
+		return err
+	}
+	if err := p.write(append([]byte{SetPreferences}, prefs...)); err != nil {
+		return err
+	}
+
+	defer close(p.done)
+	go p.processReceive()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	p.setClock(0)
+	if err := p.sendTitle(); err != nil {
+		return err
+	}
+	for {
+		// Also finishes recordings without events and seeks past the last event
+		if !p.paused && p.next >= len(p.recording.events) {
+			p.paused = true
+			p.setClock(p.recording.duration)
+			if err := p.sendTitle(); err != nil {
+				return err
+			}
+		}
+
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !p.paused && p.next < len(p.recording.events) {
+			delay := (p.recording.events[p.next].time - p.position()) / p.speed
+			timer = time.NewTimer(time.Duration(delay * float64(time.Second)))
+			fire = timer.C
+		}
+
+		select {
+		case <-fire:
+			err = p.flush(p.position())
+		case key, ok := <-p.keys:
+			if !ok {
+				return nil
+			}
+			if err = p.control(key); err == nil {
+				err = p.sendTitle()
+			}
+		case <-ticker.C:
+			if !p.paused {
+				err = p.sendTitle()
+			}
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *player) processReceive() {
+	defer close(p.keys)
+
+	for {
+		_, data, err := p.connection.ReadMessage()
+		if err != nil {
+			return
+		}
+		if len(data) == 0 {
+			return
+		}
+
+		switch data[0] {
+		case Input:
+			select {
+			case p.keys <- string(data[1:]):
+			case <-p.done:
+				return
+			}
+		case Ping:
+			if err := p.write([]byte{Pong}); err != nil {
+				return
+			}
+		case ResizeTerminal:
+			// The window size of the recording is fixed
+		default:
+			return
+		}
+	}
+}
+
+// control handles a keystroke from the client.
+//
+//	Space:           pause or resume
+//	Left, Right:     seek backward or forward
+//	Up, Down, +, -:  change speed
+//	0-9:             jump to 0%-90%
+func (p *player) control(key string) error {
+	position := p.position()
+
+	switch key {
+	case " ":
+		if p.paused && p.next >= len(p.recording.events) {
+			if err := p.seek(0); err != nil {
+				return err
+			}
+			p.paused = false
+			p.setClock(0)
+			return nil
+		}
+		p.paused = !p.paused
+		p.setClock(position)
+	case "\x1b[C", "\x1bOC", "l":
+		return p.seek(position + playbackSeekStep)
+	case "\x1b[D", "\x1bOD", "h":
+		return p.seek(position - playbackSeekStep)
+	case "\x1b[A", "\x1bOA", "+", "k":
+		if p.speed < playbackMaxSpeed {
+			p.speed *= 2
+		}
+		p.setClock(position)
+	case "\x1b[B", "\x1bOB", "-", "j":
+		if p.speed > 1.0/playbackMaxSpeed {
+			p.speed /= 2
+		}
+		p.setClock(position)
+	default:
+		if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
+			return p.seek(p.recording.duration * float64(key[0]-'0') / 10)
+		}
+	}
+	return nil
+}
+
+// seek moves the playback to the given position.
+// Seeking backward resets the terminal and replays the recording from the beginning.
+func (p *player) seek(position float64) error {
+	if position < 0 {
+		position = 0
+	}
+	if position > p.recording.duration {
+		position = p.recording.duration
+	}
+
+	if position < p.position() {
+		p.next = 0
+		if err := p.write(outputMessage([]byte("\x1bc"))); err != nil {
+			return err
+		}
+	}
+	if err := p.flush(position); err != nil {
+		return err
+	}
+	p.setClock(position)
+	return nil
+}
+
+// flush sends all events up to the position at once.
+func (p *player) flush(position float64) error {
+	buf := new(bytes.Buffer)
+	for p.next < len(p.recording.events) && p.recording.events[p.next].time <= position {
+		buf.WriteString(p.recording.events[p.next].data)
+		p.next++
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return p.write(outputMessage(buf.Bytes()))
+}
+
+func (p *player) position() float64 {
+	if p.paused {
+		return p.base
+	}
+	return p.base + time.Since(p.baseTime).Seconds()*p.speed
+}
+
+func (p *player) setClock(position float64) {
+	p.base = position
+	p.baseTime = time.Now()
+}
+
+func (p *player) sendTitle() error {
+	state := "playing"
+	if p.paused {
+		state = "paused"
+		if p.next >= len(p.recording.events) {
+			state = "finished"
+		}
+	}
+	position := p.position()
+	if position > p.recording.duration {
+		position = p.recording.duration
+	}
+
+	title := fmt.Sprintf(
+		"GoTTY playback - %s [%s %sx %s / %s]",
+		p.name, state, strconv.FormatFloat(p.speed, 'f', -1, 64),
+		formatPlaybackTime(position), formatPlaybackTime(p.recording.duration),
+	)
+	return p.write(append([]byte{SetWindowTitle}, []byte(title)...))
+}
+
+func (p *player) write(data []byte) error {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	return p.connection.WriteMessage(websocket.TextMessage, data)
+}
+
+func formatPlaybackTime(seconds float64) string {
+	total := int(seconds)
+	return fmt.Sprintf("%02d:%02d", total/60, total%60)
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// playTestRecording plays the recording to a websocket client sending the keys,
+// and returns the output until the playback finishes.
+func playTestRecording(t *testing.T, rec *recording, keys ...string) string {
+	options := DefaultOptions
+	options.LogOutput = ioutil.Discard
+	app, err := New([]string{"cat"}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := app.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		p := &player{
+			app:        app,
+			name:       "test.cast",
+			recording:  rec,
+			connection: conn,
+			writeMutex: &sync.Mutex{},
+			keys:       make(chan string),
+			done:       make(chan struct{}),
+			speed:      1,
+		}
+		p.run()
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, key := range keys {
+		if err := conn.WriteMessage(websocket.TextMessage, append([]byte{Input}, key...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := ""
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("The playback did not finish: %v (output %q)", err, output)
+		}
+		switch data[0] {
+		case Output:
+			decoded, err := base64.StdEncoding.DecodeString(string(data[1:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			output += string(decoded)
+		case SetWindowTitle:
+			if strings.Contains(string(data), "[finished ") {
+				return output
+			}
+		}
+	}
+}
+
+func TestPlaybackFinishes(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []playbackEvent
+		keys   []string
+		output string
+	}{
+		{"no events", nil, nil, ""},
+		{"events", []playbackEvent{{0, "a"}, {0.05, "b"}}, nil, "ab"},
+		{"seek past the end", []playbackEvent{{0, "a"}, {60, "b"}}, []string{"9", "l", "l"}, "ab"},
+	}
+	for _, test := range tests {
+		rec := &recording{header: asciicastHeader{Version: 2, Width: 80, Height: 24}, events: test.events}
+		if len(test.events) > 0 {
+			rec.duration = test.events[len(test.events)-1].time
+		}
+		if output := playTestRecording(t, rec, test.keys...); output != test.output {
+			t.Errorf("%s: expected the output %q, got %q", test.name, test.output, output)
+		}
+	}
+}
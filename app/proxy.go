@@ -0,0 +1,160 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// forwardedContextKey is the key of the forwarded information of requests from trusted proxies.
+const forwardedContextKey contextKey = 1
+
+// trustedProxies are the proxies whose X-Forwarded-* headers and PROXY protocol headers are honored.
+type trustedProxies struct {
+	networks []*net.IPNet
+	unix     bool // clients of Unix domain sockets are trusted
+}
+
+// forwarded is the original request told by a trusted proxy.
+type forwarded struct {
+	proto  string // "http" or "https", empty when not told
+	prefix string // path prefix stripped by the proxy, without the trailing slash
+}
+
+// newTrustedProxies parses IP addresses and CIDRs of proxies.
+// "unix" trusts all clients of Unix domain sockets.
+func newTrustedProxies(entries []string) (*trustedProxies, error) {
+	proxies := &trustedProxies{}
+	for _, entry := range entries {
+		if entry == "unix" {
+			proxies.unix = true
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New("Invalid trusted proxy: " + entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies.networks = append(proxies.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("Invalid trusted proxy: " + entry)
+		}
+		proxies.networks = append(proxies.networks, network)
+	}
+	return proxies, nil
+}
+
+// contains reports whether the address, with or without a port, is a trusted proxy.
+// Addresses which are not IP addresses belong to Unix domain sockets.
+func (proxies *trustedProxies) contains(addr string) bool {
+	ip := parseAddrIP(addr)
+	if ip == nil {
+		return proxies.unix
+	}
+	for _, network := range proxies.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the client in X-Forwarded-For, which is the last address not of a trusted proxy,
+// or an empty string when no valid address is given.
+func (proxies *trustedProxies) clientIP(values []string) string {
+	addrs := strings.Split(strings.Join(values, ","), ",")
+	client := ""
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := parseAddrIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !proxies.contains(client) {
+			break
+		}
+	}
+	return client
+}
+
+func parseAddrIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+// wrapProxy applies X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Prefix of requests from trusted proxies,
+// replacing the remote address of requests with the address of the client.
+func (app *App) wrapProxy(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.proxies.contains(r.RemoteAddr) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		fwd := &forwarded{}
+		proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+		if proto == "http" || proto == "https" {
+			fwd.proto = proto
+		}
+		if prefix := r.Header.Get("X-Forwarded-Prefix"); strings.HasPrefix(prefix, "/") {
+			fwd.prefix = strings.TrimSuffix(path.Clean(prefix), "/")
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), forwardedContextKey, fwd))
+		if client := app.proxies.clientIP(r.Header.Values("X-Forwarded-For")); client != "" {
+			r.RemoteAddr = client
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// requestScheme returns the scheme of the URL the client requested.
+func requestScheme(r *http.Request) string {
+	if fwd, ok := r.Context().Value(forwardedContextKey).(*forwarded); ok && fwd.proto != "" {
+		return fwd.proto
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// isSecure reports whether the client uses HTTPS, so that cookies must be secure.
+func isSecure(r *http.Request) bool {
+	return requestScheme(r) == "https"
+}
+
+// publicPath returns the path of the server as seen by the client,
+// prepending the prefix stripped by the proxy or the path of the external URL.
+func (app *App) publicPath(r *http.Request, p string) string {
+	if fwd, ok := r.Context().Value(forwardedContextKey).(*forwarded); ok && fwd.prefix != "" {
+		return fwd.prefix + p
+	}
+	if app.options.ExternalURL != "" {
+		if u, err := url.Parse(app.options.ExternalURL); err == nil {
+			return strings.TrimSuffix(u.Path, "/") + p
+		}
+	}
+	return p
+}
+
+// absoluteURL returns the URL of the path of the server as seen by the client.
+func (app *App) absoluteURL(r *http.Request, p string) string {
+	if app.options.ExternalURL != "" {
+		return strings.TrimSuffix(app.options.ExternalURL, "/") + p
+	}
+	return requestScheme(r) + "://" + r.Host + app.publicPath(r, p)
+}
@@ -0,0 +1,163 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout is the time to wait for the PROXY protocol header of a connection.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyProtocolSignature starts the PROXY protocol version 2 header.
+var proxyProtocolSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolListener reads the PROXY protocol header of connections from trusted proxies
+// and reports the address of the client given by the header as the remote address.
+type proxyProtocolListener struct {
+	net.Listener
+	proxies *trustedProxies
+	logger  *logger
+}
+
+func (listener *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !listener.proxies.contains(conn.RemoteAddr().String()) {
+		return conn, nil
+	}
+	return &proxyProtocolConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		logger: listener.logger,
+	}, nil
+}
+
+// proxyProtocolConn reads the header when the connection is used for the first time,
+// so that a slow proxy doesn't block accepting other connections.
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+	logger *logger
+
+	once       sync.Once
+	remoteAddr net.Addr // nil when the header doesn't give an address
+	err        error
+}
+
+func (conn *proxyProtocolConn) readHeader() {
+	conn.once.Do(func() {
+		conn.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		conn.remoteAddr, conn.err = readProxyHeader(conn.reader)
+		conn.Conn.SetReadDeadline(time.Time{})
+		if conn.err != nil {
+			conn.logger.with("remote_addr", conn.Conn.RemoteAddr()).warnf("Invalid PROXY protocol header: %v", conn.err)
+		}
+	})
+}
+
+func (conn *proxyProtocolConn) Read(p []byte) (int, error) {
+	conn.readHeader()
+	if conn.err != nil {
+		return 0, conn.err
+	}
+	return conn.reader.Read(p)
+}
+
+func (conn *proxyProtocolConn) RemoteAddr() net.Addr {
+	conn.readHeader()
+	if conn.remoteAddr != nil {
+		return conn.remoteAddr
+	}
+	return conn.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads the PROXY protocol header of version 1 or 2
+// and returns the source address, or nil for local connections and unknown protocols.
+func readProxyHeader(reader *bufio.Reader) (net.Addr, error) {
+	start, err := reader.Peek(len(proxyProtocolSignature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, proxyProtocolSignature) {
+		return readProxyHeaderV2(reader)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readProxyHeaderV1(reader)
+	}
+	return nil, errors.New("No PROXY protocol header")
+}
+
+func readProxyHeaderV1(reader *bufio.Reader) (net.Addr, error) {
+	// The header is at most 107 bytes long including CRLF
+	line := []byte{}
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 {
+			return nil, errors.New("PROXY protocol header too long")
+		}
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("Malformed PROXY protocol header: " + strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, errors.New("Malformed PROXY protocol header: " + strings.TrimSpace(string(line)))
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, errors.New("Unsupported PROXY protocol version " + strconv.Itoa(int(header[12]>>4)))
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections are health checks of the proxy itself
+	switch header[12] & 0x0f {
+	case 0:
+		return nil, nil
+	case 1: // PROXY
+	default:
+		return nil, errors.New("Unsupported PROXY protocol command " + strconv.Itoa(int(header[12]&0x0f)))
+	}
+	switch header[13] >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errors.New("Truncated PROXY protocol header")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errors.New("Truncated PROXY protocol header")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyHeaderV2 builds a version 2 header with the command, the family and the payload.
+func proxyHeaderV2(versionCommand byte, family byte, payload []byte) []byte {
+	header := append([]byte{}, proxyProtocolSignature...)
+	header = append(header, versionCommand, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func inet4Payload(src string, dst string, srcPort uint16, dstPort uint16) []byte {
+	payload := append(append([]byte{}, net.ParseIP(src).To4()...), net.ParseIP(dst).To4()...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], srcPort)
+	binary.BigEndian.PutUint16(ports[2:4], dstPort)
+	return append(payload, ports...)
+}
+
+func inet6Payload(src string, dst string, srcPort uint16, dstPort uint16) []byte {
+	payload := append(append([]byte{}, net.ParseIP(src).To16()...), net.ParseIP(dst).To16()...)
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports[0:2], srcPort)
+	binary.BigEndian.PutUint16(ports[2:4], dstPort)
+	return append(payload, ports...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tlv := []byte{0x02, 0x00, 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e'} // PP2_TYPE_AUTHORITY
+
+	tests := []struct {
+		name   string
+		header []byte
+		addr   string // empty for no address
+		err    bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"), "192.0.2.1:56324", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "[2001:db8::1]:56324", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 UNKNOWN with addresses", []byte("PROXY UNKNOWN 192.0.2.1 198.51.100.1 56324 443\r\n"), "", false},
+		{"v1 unknown protocol", []byte("PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n"), "", true},
+		{"v1 missing fields", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n"), "", true},
+		{"v1 invalid address", []byte("PROXY TCP4 192.0.2.999 198.51.100.1 56324 443\r\n"), "", true},
+		{"v1 address of another family", []byte("PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n"), "", true},
+		{"v1 invalid port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n"), "", true},
+		{"v1 without CRLF", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"), "", true},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n"), "", true},
+		{"v1 truncated", []byte("PROXY TCP4 192.0.2.1 198.51"), "", true},
+		{"v2 TCP4", proxyHeaderV2(0x21, 0x11, inet4Payload("192.0.2.1", "198.51.100.1", 56324, 443)), "192.0.2.1:56324", false},
+		{"v2 TCP6", proxyHeaderV2(0x21, 0x21, inet6Payload("2001:db8::1", "2001:db8::2", 56324, 443)), "[2001:db8::1]:56324", false},
+		{"v2 with TLVs", proxyHeaderV2(0x21, 0x11, append(inet4Payload("192.0.2.1", "198.51.100.1", 56324, 443), tlv...)), "192.0.2.1:56324", false},
+		{"v2 LOCAL", proxyHeaderV2(0x20, 0x00, nil), "", false},
+		{"v2 LOCAL with addresses", proxyHeaderV2(0x20, 0x11, inet4Payload("192.0.2.1", "198.51.100.1", 56324, 443)), "", false},
+		{"v2 UNSPEC", proxyHeaderV2(0x21, 0x00, nil), "", false},
+		{"v2 unknown command", proxyHeaderV2(0x22, 0x11, inet4Payload("192.0.2.1", "198.51.100.1", 56324, 443)), "", true},
+		{"v2 unknown version", proxyHeaderV2(0x11, 0x11, inet4Payload("192.0.2.1", "198.51.100.1", 56324, 443)), "", true},
+		{"v2 short TCP4 payload", proxyHeaderV2(0x21, 0x11, make([]byte, 11)), "", true},
+		{"v2 short TCP6 payload", proxyHeaderV2(0x21, 0x21, make([]byte, 35)), "", true},
+		{"v2 truncated payload", proxyHeaderV2(0x21, 0x11, inet4Payload("192.0.2.1", "198.51.100.1", 56324, 443))[:20], "", true},
+		{"v2 truncated header", proxyHeaderV2(0x21, 0x11, nil)[:14], "", true},
+		{"no header", []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"), "", true},
+		{"empty", []byte{}, "", true},
+	}
+	for _, test := range tests {
+		request := []byte("GET / HTTP/1.1\r\n\r\n")
+		reader := bufio.NewReader(bytes.NewReader(append(append([]byte{}, test.header...), request...)))
+		// Truncated headers end the connection
+		if strings.Contains(test.name, "truncated") || test.name == "empty" {
+			reader = bufio.NewReader(bytes.NewReader(test.header))
+		}
+
+		addr, err := readProxyHeader(reader)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", test.name, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if (addr == nil && test.addr != "") || (addr != nil && addr.String() != test.addr) {
+			t.Errorf("%s: expected %q, got %v", test.name, test.addr, addr)
+		}
+		// The request following the header is left to the server
+		rest := make([]byte, len(request))
+		if n, _ := reader.Read(rest); !bytes.Equal(rest[:n], request) {
+			t.Errorf("%s: expected the request after the header, got %q", test.name, rest[:n])
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := newTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		values []string
+		client string
+	}{
+		{"no header", nil, ""},
+		{"empty", []string{""}, ""},
+		{"client", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"client behind proxies", []string{"198.51.100.7, 10.0.0.2, 192.0.2.1"}, "198.51.100.7"},
+		{"spoofed hops before the client", []string{"203.0.113.9, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"untrusted hop after the client", []string{"198.51.100.7, 203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"only proxies", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"multiple headers", []string{"203.0.113.9", "198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		{"garbage before the client", []string{"garbage, 198.51.100.7"}, "198.51.100.7"},
+		{"garbage from a proxy", []string{"198.51.100.7, garbage"}, ""},
+		{"garbage between proxies", []string{"198.51.100.7, garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"with ports", []string{"198.51.100.7:1234, 10.0.0.2:80"}, "198.51.100.7"},
+		{"IPv6", []string{"2001:db8::7, 2001:db8::1"}, "2001:db8::7"},
+		{"IPv6 with port", []string{"[2001:db8::7]:1234"}, "2001:db8::7"},
+		{"spaces", []string{"  198.51.100.7 ,10.0.0.2  "}, "198.51.100.7"},
+	}
+	for _, test := range tests {
+		if client := proxies.clientIP(test.values); client != test.client {
+			t.Errorf("%s: expected %q, got %q", test.name, test.client, client)
+		}
+	}
+}
+
+func TestNewTrustedProxiesInvalid(t *testing.T) {
+	for _, entry := range []string{"", "example.com", "10.0.0.0/33", "10.0.0.300"} {
+		if _, err := newTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("%q is accepted", entry)
+		}
+	}
+}
+
+func TestWrapProxy(t *testing.T) {
+	proxies, err := newTrustedProxies([]string{"10.0.0.0/8", "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &App{proxies: proxies, options: &Options{}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     map[string]string
+		expected   string
+		scheme     string
+		path       string
+	}{
+		{"untrusted", "203.0.113.9:1234", map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https", "X-Forwarded-Prefix": "/gotty"}, "203.0.113.9:1234", "http", "/ws"},
+		{"trusted", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https", "X-Forwarded-Prefix": "/gotty/"}, "198.51.100.7", "https", "/gotty/ws"},
+		{"trusted without headers", "10.0.0.2:1234", nil, "10.0.0.2:1234", "http", "/ws"},
+		{"unix socket", "@", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7", "http", "/ws"},
+		{"invalid proto and prefix", "10.0.0.2:1234", map[string]string{"X-Forwarded-Proto": "gopher", "X-Forwarded-Prefix": "gotty"}, "10.0.0.2:1234", "http", "/ws"},
+	}
+	for _, test := range tests {
+		var got *http.Request
+		handler := app.wrapProxy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r
+		}))
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.RemoteAddr = test.remoteAddr
+		for name, value := range test.header {
+			r.Header.Set(name, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		if got.RemoteAddr != test.expected {
+			t.Errorf("%s: expected the address %q, got %q", test.name, test.expected, got.RemoteAddr)
+		}
+		if scheme := requestScheme(got); scheme != test.scheme {
+			t.Errorf("%s: expected the scheme %q, got %q", test.name, test.scheme, scheme)
+		}
+		if path := app.publicPath(got, "/ws"); path != test.path {
+			t.Errorf("%s: expected the path %q, got %q", test.name, test.path, path)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// recorder writes a session to a file in the asciicast v2 format.
+// See https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md
+type recorder struct {
+	file  *os.File
+	mutex *sync.Mutex
+
+	header        asciicastHeader
+	headerWritten bool
+	pending       [][]interface{} // events recorded before the window size is known
+	start         time.Time
+
+	// incomplete UTF-8 sequences carried over to the next event
+	outputRest []byte
+	inputRest  []byte
+}
+
+type asciicastHeader struct {
+	Version       int     `json:"version"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	Timestamp     int64   `json:"timestamp"`
+	IdleTimeLimit float64 `json:"idle_time_limit,omitempty"`
+	Command       string  `json:"command,omitempty"`
+	Title         string  `json:"title,omitempty"`
+	Owner         string  `json:"owner,omitempty"` // user who started the session, checked by playback
+}
+
+const (
+	defaultRecordWidth  = 80
+	defaultRecordHeight = 24
+)
+
+func newRecorder(dir string, name string, command string, owner string) (*recorder, error) {
+	dir = ExpandHomeDir(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, name+".cast")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	return &recorder{
+		file:  file,
+		mutex: &sync.Mutex{},
+		header: asciicastHeader{
+			Version:   2,
+			Timestamp: start.Unix(),
+			Command:   command,
+			Owner:     owner,
+		},
+		start: start,
+	}, nil
+}
+
+func (r *recorder) output(data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var text string
+	text, r.outputRest = splitIncompleteRune(r.outputRest, data)
+	r.event("o", text)
+}
+
+func (r *recorder) input(data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var text string
+	text, r.inputRest = splitIncompleteRune(r.inputRest, data)
+	r.event("i", text)
+}
+
+func (r *recorder) resize(columns int, rows int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.headerWritten {
+		r.header.Width = columns
+		r.header.Height = rows
+		r.writeHeader()
+		return
+	}
+	r.event("r", fmt.Sprintf("%dx%d", columns, rows))
+}
+
+func (r *recorder) close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.headerWritten {
+		r.header.Width = defaultRecordWidth
+		r.header.Height = defaultRecordHeight
+		r.writeHeader()
+	}
+	return r.file.Close()
+}
+
+func (r *recorder) event(eventType string, data string) {
+	if data == "" {
+		return
+	}
+
+	event := []interface{}{time.Since(r.start).Seconds(), eventType, data}
+	if !r.headerWritten {
+		r.pending = append(r.pending, event)
+		return
+	}
+	r.writeLine(event)
+}
+
+func (r *recorder) writeHeader() {
+	r.headerWritten = true
+	r.writeLine(r.header)
+	for _, event := range r.pending {
+		r.writeLine(event)
+	}
+	r.pending = nil
+}
+
+func (r *recorder) writeLine(value interface{}) {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	r.file.Write(append(line, '\n'))
+}
+
+// splitIncompleteRune prepends rest to data and returns the text
+// without a trailing incomplete UTF-8 sequence, and that sequence.
+func splitIncompleteRune(rest []byte, data []byte) (string, []byte) {
+	buf := append(rest, data...)
+
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(buf[i]) {
+			continue
+		}
+		if !utf8.FullRune(buf[i:]) {
+			return string(buf[:i]), append([]byte{}, buf[i:]...)
+		}
+		break
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitIncompleteRune(t *testing.T) {
+	data := []byte("aé€\U0001F600b\U0001F600")
+
+	// Two reads split at every offset
+	for i := 0; i <= len(data); i++ {
+		first, rest := splitIncompleteRune(nil, data[:i])
+		second, rest := splitIncompleteRune(rest, data[i:])
+		if !utf8.ValidString(first) || !utf8.ValidString(second) {
+			t.Errorf("Split at %d: invalid text %q, %q", i, first, second)
+		}
+		if first+second != string(data) || len(rest) != 0 {
+			t.Errorf("Split at %d: expected %q, got %q + %q with %q left", i, data, first, second, rest)
+		}
+	}
+
+	// Three reads split at every pair of offsets
+	for i := 0; i <= len(data); i++ {
+		for j := i; j <= len(data); j++ {
+			text := ""
+			var rest []byte
+			for _, part := range [][]byte{data[:i], data[i:j], data[j:]} {
+				var chunk string
+				chunk, rest = splitIncompleteRune(rest, part)
+				if !utf8.ValidString(chunk) {
+					t.Errorf("Split at %d and %d: invalid text %q", i, j, chunk)
+				}
+				text += chunk
+			}
+			if text != string(data) || len(rest) != 0 {
+				t.Errorf("Split at %d and %d: expected %q, got %q with %q left", i, j, data, text, rest)
+			}
+		}
+	}
+}
+
+func TestSplitIncompleteRuneInvalid(t *testing.T) {
+	tests := []struct {
+		data string
+		text string
+		rest string
+	}{
+		{"\xff", "\xff", ""},
+		{"a\x80", "a\x80", ""},
+		{"\xe2\x82", "", "\xe2\x82"},
+		{"ab\xf0\x9f\x98", "ab", "\xf0\x9f\x98"},
+		{"\xe2\x82a", "\xe2\x82a", ""},
+	}
+	for _, test := range tests {
+		text, rest := splitIncompleteRune(nil, []byte(test.data))
+		if text != test.text || string(rest) != test.rest {
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.data, test.text, test.rest, text, rest)
+		}
+	}
+}
+
+func readAsciicast(t *testing.T, path string) (map[string]interface{}, [][]interface{}) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("No header")
+	}
+	header := map[string]interface{}{}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatal(err)
+	}
+	events := [][]interface{}{}
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Malformed event %s: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+func TestRecorderAsciicast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := newRecorder(dir, "test", "bash", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	euro := []byte("€")
+	r.output([]byte("hello\r\n")) // before the window size is known
+	r.resize(100, 30)
+	r.input([]byte("ls"))
+	r.output(euro[:1])
+	r.output(euro[1:])
+	r.resize(120, 40)
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	header, events := readAsciicast(t, filepath.Join(dir, "test.cast"))
+	for key, value := range map[string]interface{}{"version": 2.0, "width": 100.0, "height": 30.0, "command": "bash", "owner": "alice"} {
+		if header[key] != value {
+			t.Errorf("Expected %v of %s in the header, got %v", value, key, header[key])
+		}
+	}
+	if timestamp, _ := header["timestamp"].(float64); timestamp <= 0 {
+		t.Errorf("Invalid timestamp: %v", header["timestamp"])
+	}
+
+	expected := [][2]string{{"o", "hello\r\n"}, {"i", "ls"}, {"o", "€"}, {"r", "120x40"}}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %v", len(expected), events)
+	}
+	last := 0.0
+	for i, event := range events {
+		if len(event) != 3 {
+			t.Fatalf("Malformed event: %v", event)
+		}
+		eventTime, _ := event[0].(float64)
+		if eventTime < last {
+			t.Errorf("Event %d goes back in time: %v", i, event)
+		}
+		last = eventTime
+		if event[1] != expected[i][0] || event[2] != expected[i][1] {
+			t.Errorf("Expected %v, got %v", expected[i], event)
+		}
+	}
+}
+
+func TestRecorderDefaultSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := newRecorder(dir, "test", "bash", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.output([]byte("bye"))
+	r.close()
+
+	header, events := readAsciicast(t, filepath.Join(dir, "test.cast"))
+	if header["width"] != float64(defaultRecordWidth) || header["height"] != float64(defaultRecordHeight) {
+		t.Errorf("Expected the default size, got %vx%v", header["width"], header["height"])
+	}
+	if _, ok := header["owner"]; ok {
+		t.Error("Anonymous recordings have an owner")
+	}
+	if len(events) != 1 || events[0][2] != "bye" {
+		t.Errorf("Unexpected events: %v", events)
+	}
+
+	if _, err := newRecorder(dir, "test", "bash", ""); err == nil {
+		t.Error("An existing recording is overwritten")
+	}
+}
@@ -111,7 +111,7 @@ func staticIndexHtml() (*asset, error) {
 	return a, nil
 }
 
-var _staticJsGottyJs = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x56\xdf\x6f\xdb\x36\x10\x7e\xcf\x5f\x71\xe0\x4b\xa8\x45\x55\x9c\x76\x0f\x83\x0d\x6f\xe8\x8a\x0c\xe8\x3a\x2c\x45\xed\x2d\x0f\x41\x50\xd0\xd4\x59\xe2\x4c\x93\x06\x79\x8a\xa0\x15\xfe\xdf\x0b\x4a\xfe\x21\xcb\x52\xdd\xf2\xc1\xa6\xc8\xfb\xbe\xfb\xee\xc8\x23\xc9\x97\x85\x91\xa4\xac\xe1\x11\x7c\xb9\x02\x00\x78\x11\x0e\x72\xa2\x8d\xbf\x37\x62\xa1\x31\x85\x29\x94\xca\xa4\xb6\x4c\xb4\x95\x22\x98\x26\x1b\x67\xc9\x4a\xab\x61\x3a\x05\x56\xdb\x8e\xd9\xe4\x00\x16\x2e\xf3\x3d\x20\x8f\xc2\xc9\xfc\x68\x56\x38\x0d\x53\xe0\x27\xae\x7e\x83\xeb\xd2\xfb\xf1\xed\xed\x35\x8c\x43\x37\xf4\x22\xb8\x39\xe3\xca\xad\xa7\x9e\xe1\x8d\xa0\xdc\x88\x35\xc2\x4d\x00\x5f\x1f\x7d\xed\x05\x07\x5d\x4f\x2c\xb3\x44\x15\x7b\x6e\x29\x2e\xc8\x7e\x42\x69\x8d\x41\x49\x30\x85\x57\x77\x93\xab\xc3\xa4\xdd\xa0\x79\x0c\xc0\xb3\x4c\xed\x2d\xca\x30\x6b\xb0\x84\x47\x5c\xcc\xac\x5c\x21\xf1\xc2\xe9\xf8\xe8\x35\xda\xd1\xed\x01\x84\x6e\xdd\x19\xda\x28\x93\xcd\xd5\x1a\x5d\x6b\xbc\xf4\x89\x35\xc1\x7d\xdb\x39\xbe\xa0\xa1\xb6\x82\x9d\xa5\x47\x93\xf2\x3f\x67\x0f\x7f\x27\x9e\x9c\x32\x99\x5a\x56\xfc\x0b\xbc\x75\x59\xb1\x46\x43\x7e\x5c\x2f\x4b\x0c\x6f\x0b\xca\xe7\x76\x85\x66\x0c\x75\x1a\x3e\x8b\x82\xf2\xcf\x14\x46\xe2\x6d\x14\x4d\x4e\x68\x0f\xa2\x60\x0a\x1e\xe9\xbd\x21\x74\x2f\x42\xf3\xe0\xeb\xa3\x32\x59\x0c\x6f\x46\xf0\x13\xdc\x8d\x46\xa3\x18\xca\x93\x30\x43\xcb\x43\x9c\x49\x8a\x4b\x51\x68\x9a\x91\x75\x22\xc3\x5d\xa6\xb4\x5a\x24\xbb\x91\xe4\x2f\x2b\x85\xe6\x1d\xd7\x7d\xd8\x44\x6a\x14\x8e\x77\xdd\x04\xcb\x1d\x6d\x83\x9a\xa3\x5b\x2b\xd3\x70\x9e\x59\x26\x19\xd2\x47\x87\x4b\xcf\xa3\xc4\x23\x71\x16\x82\x79\x85\x46\xda\x54\x99\x8c\xc5\xc0\x9c\x28\x59\x2f\xd2\x9a\x3d\xf3\x27\x14\x69\x35\xb4\x25\xda\xcb\xaa\x2c\x4c\x1b\xb0\xb2\xc9\xa6\xf0\xf9\x99\xa6\xd0\x94\x4d\xac\xf9\x77\xfe\x01\x2b\x4f\xce\xae\xb0\xcd\xec\xc9\xf5\x91\xb7\x57\x9d\x8d\x18\xdc\x40\x30\x9c\x9c\xd9\x6d\xfb\xdd\x05\xdc\xac\xde\x27\x30\x3d\x73\x3f\xa4\xf0\x18\xbd\x57\xff\x9f\x88\x94\x56\x17\x6b\xe3\x63\x70\xb6\xf4\x97\xe4\xf6\x4e\x86\xc6\x5e\x87\x38\x3a\x7b\x78\xd0\x3a\xb4\x7e\x47\xed\xb6\x53\x36\xde\x77\xe2\x8b\x88\x10\xc2\xb8\xfe\xfd\xb6\xed\x76\x70\x36\xea\x9d\x39\x1f\xed\x5b\x9b\x66\xaf\x18\x4f\x42\xeb\x0f\x58\x2d\xac\x70\x69\xb7\x36\xba\xb8\x5d\xa9\x48\xeb\x04\x21\x4f\xad\xac\x4b\x3e\x6c\xf4\x7b\x8d\xa1\xfb\x7b\xf5\x3e\xe5\x8c\x76\xcb\xc7\xda\x65\xbe\xed\x9e\x37\x6b\xf4\xbe\xa9\xd3\x6f\x1f\x39\xa9\x20\x01\x53\xa8\xe7\x92\xf0\x91\x78\xad\x24\xf2\xbb\x8e\x58\x5f\x2a\x92\x39\x3f\xda\x3d\x8d\x9e\xbb\x5c\x52\x78\x84\xeb\xd1\xf5\x78\x20\x1d\x36\x29\x9d\x22\xfc\x67\xfe\xc7\x2f\x7c\x77\xe6\x0b\xb2\x0b\x1e\xe8\xba\x67\x56\x68\x0b\x87\x62\x35\xe9\x71\x71\xd7\xe3\xe2\xf6\x16\x36\xd6\x64\xdf\x4f\xf2\x7a\x48\xa7\x47\x7a\xac\xd5\xcd\x15\x69\x6c\xd4\xfd\x80\xb8\x37\x3d\xbc\x1b\x87\x4b\x74\x68\x24\x86\x3b\xa6\x2e\x8d\x8d\x70\x7e\x90\xfc\x61\xf1\x1f\x4a\x4a\x56\x58\x79\xde\xc2\x46\xc9\xd2\xba\x7b\x21\xf3\xe3\x75\xbf\xc2\x6a\xa8\x50\xa5\x35\xde\x6a\x4c\xb4\xcd\x38\x9b\x21\x51\x38\x26\x42\x69\xae\xb0\x82\x1b\x60\xe3\xfa\xa3\xad\xed\x69\x85\xd5\x73\x8f\x1c\x18\x38\x74\x57\x58\xc5\xdf\x83\xdf\xfe\x48\xfe\x7e\xee\xc9\x5f\xf7\x66\xbf\x9c\xc1\x93\xe0\xeb\x77\x49\x88\xde\xed\x39\x9a\xd8\x4f\x69\x6f\x80\x81\x0f\x5f\xa9\x67\xe7\x55\xde\xa3\x77\x3b\x5c\x7e\x52\x5b\x7f\xb9\xf8\xd4\x12\x78\xc8\x6b\xdf\x0a\xd6\xf9\x2e\xcc\x85\x33\x04\x5a\xc5\xe5\x73\x5b\x3e\xbc\xa0\xd3\xa2\xe2\xec\x5d\x13\x94\xb2\x06\xde\x05\x2d\x29\x8b\xc1\x14\x5a\x77\xcf\xa0\xd3\x05\x08\x17\xf3\xe1\x79\x70\x78\x36\x74\x30\x41\xf5\x69\xe6\x7e\x85\x51\x5f\x08\x1e\x29\xe0\x6d\x41\xbc\x79\x7d\xc5\x9d\x8c\x37\x6f\x8e\x41\x49\xdb\x66\x62\x7b\x75\x7c\xc5\xed\x9f\x2c\xed\xd4\x9e\x5e\x55\x87\xdb\xf4\x8e\x45\x07\x7c\xf8\x6b\x24\x84\x04\x6e\x23\x1e\x5d\x7d\x0d\x00\x00\xff\xff\x9e\x90\xaa\xec\x32\x0b\x00\x00")
+var _staticJsGottyJs = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\xff\xad\x57\x5b\x6f\xdb\x36\x14\x7e\xcf\xaf\x20\xf4\x12\x6a\x53\x18\xa7\xed\x86\xc1\x41\x56\x74\x69\x06\xa4\x2b\x96\xa2\xf6\x96\x87\x20\x28\x68\xe9\xd8\x62\x23\x93\x02\x49\x45\xf0\x02\xff\xf7\x1d\x4a\xb2\x25\xcb\x54\xe2\x0c\xe3\x83\x20\x91\xe7\xf2\x9d\x2b\x8f\xe8\xbc\x90\xb1\x15\x4a\xd2\x90\x3c\x1d\x11\x5c\x8f\x5c\x93\xd4\xda\xdc\x5c\x49\x3e\xcb\x20\x21\x17\xa4\x14\x32\x51\x25\xcb\x54\xcc\x1d\x29\xcb\xb5\xb2\x2a\x56\x19\xb9\xb8\x20\x41\x45\x3b\x0e\xce\xb7\xcc\x5c\x2f\x8c\x87\xc9\x00\xd7\x71\xda\x92\x15\x1a\xf9\x09\xdd\x51\xf5\x9e\x1c\x97\xc6\x8c\x4f\x4f\x8f\xc9\xd8\xbd\xba\xb7\x90\xfc\xb8\x27\x2b\x55\xc6\x7a\xb6\x73\x6e\x53\xc9\x97\x80\x47\xc8\x7c\xdc\xea\xda\x00\x76\xb8\xee\x82\x85\xb2\x76\x15\xdc\x77\x10\x17\x56\x7d\x85\x58\x49\x09\xb1\x45\x92\x93\xb3\xf6\xcc\x80\x31\x28\xfb\xba\xe3\x87\x66\x6b\x62\x95\xe6\x0b\x60\x0b\xb0\xd7\x16\x96\xb4\x96\x7b\xd2\x9c\x9e\x88\x24\x08\xcf\x8f\xb6\x72\x54\x0e\xf2\xd6\xe9\xdf\x73\xf8\x86\xa2\x74\xa7\x12\x4a\x72\x0b\xb3\x89\x8a\x1f\xc0\x52\xf4\x51\xd4\x82\xdf\x88\xdb\x30\x58\xd0\xcb\xde\x56\x2e\xe4\x62\x2a\x96\xa0\x3b\xfb\xa5\x61\x4a\x3a\xf5\x5d\xe5\xf0\x08\xd2\x76\x11\x34\x94\x06\x64\x42\x3f\x4d\x6e\xfe\x64\xc6\x6a\x14\x26\xe6\x2b\xfa\x44\x3e\xe8\x45\xb1\x44\x06\x33\xae\xa2\x1b\x91\x0f\x85\x4d\xa7\xea\x01\xe4\x98\x54\x56\x7f\x43\x17\xa6\xdf\xac\xdb\x89\xc8\xa4\x71\xd9\xc7\x71\xeb\xbd\x68\x1d\x86\xe7\x3b\xca\xb6\x50\x11\x96\x41\x17\x4a\x34\xe7\x91\x67\xd4\x21\xf8\x82\x67\x11\x79\x3b\x22\x3f\x90\xb3\xd1\x68\x14\x21\xb2\xae\xf1\x6e\xa5\xce\x7a\x96\xc0\x9c\x17\x99\x6d\x42\xd1\xf8\x2f\x13\x33\xb6\x09\xce\x67\xcc\x8d\x8c\xf6\x54\xfb\x78\x59\x9c\x61\x82\xd2\xbe\x1a\x47\xd9\x88\xad\xb9\xa6\xf8\x10\xb2\x96\xb9\x47\xe9\x72\xe1\x8b\x86\xb9\xa1\x21\x7a\xd2\xd2\xc0\x19\x73\x02\x32\x56\x09\x5a\x14\x44\x24\xd0\xbc\x0c\xbc\x9c\x4a\x6e\x24\x7f\x05\x9e\xac\x86\x12\xa5\x1b\x6c\xa1\x90\xaa\x62\x16\x8a\xe5\x85\x49\xf7\x30\xb9\x85\x67\x4a\xfe\x3d\xfd\x03\x56\x18\x51\x0c\x50\x57\x32\xee\xf8\x84\x77\x73\x21\x18\x05\x58\x4e\x8e\xf0\x7c\x8f\x6e\xed\x57\xe7\xf8\x26\x55\xf6\xa0\xae\xbe\xfa\x21\x84\xad\xf5\x46\xfc\xb3\x03\x12\x53\xbf\x58\x4a\x4c\x3a\xad\x30\x0d\x5e\x80\xeb\x3d\x74\x2b\x78\xe3\xec\xe8\x65\xf6\x20\xb5\x5b\x4f\xcf\x9e\xba\xd5\x20\x1b\x6f\x5e\xa2\x17\x39\x9c\x09\xe3\xea\xf9\x3c\xed\x7a\xf0\x34\x3c\x3a\x6c\xd7\x17\x9b\x3a\x57\xa4\xb1\x3c\xcb\x30\x20\x33\xc5\x75\xd2\xaf\x8d\xb5\x2f\x39\x13\x6c\x8e\x9a\x5b\xa0\x89\x8a\xab\x46\xe0\x12\xfd\x2a\x03\xf7\xfa\xdb\xea\x1a\xb3\xc4\x36\xe1\x0b\xba\x65\xbe\xee\x77\xa1\x25\xb6\x83\xba\x4e\x9f\x6f\x44\x09\xb7\x1c\x89\xaa\x33\xe6\x3e\x98\xc9\x44\x0c\xf4\xac\x07\xd6\x94\xc2\xc6\x29\x6d\xe9\xee\x46\xf7\x7d\x59\x31\x37\x40\x8e\x47\xc7\xe3\x01\x77\x28\x56\x6a\x61\xe1\xaf\xe9\xef\xbf\xd0\xa6\xc1\x73\xab\x66\xd4\x89\x0b\x3d\x49\x3f\xd3\xc0\x1f\xce\x3d\x2a\xce\x3c\x2a\x4e\x4f\x49\xae\xe4\xe2\x70\x21\x6f\x86\x70\x62\x3b\xb9\xad\xd0\x4d\x85\xcd\xa0\x46\xf7\x0a\x70\x6f\x3d\x72\x73\xec\x54\xa0\xb1\x3b\x81\xbb\x79\xaa\xd2\xc8\xb9\x36\x83\xc2\x6f\x66\xdf\xf1\x7e\x64\x0f\x58\xca\xb4\xc3\x1b\xb2\xb9\xd2\x57\x1c\xe3\xb0\x0d\x2a\x92\x0c\x15\x2a\xde\xb2\x46\x65\x80\x97\xf6\x82\x06\x13\xb0\xd6\xb5\x09\x57\x9a\xc8\x83\xcf\x60\x5c\x7d\x74\xb1\xdd\xe1\xc9\xbd\x07\xce\x50\xd3\x45\xf2\xe8\x10\xfe\xf5\x6b\xfc\xf7\xce\xe3\xbf\xfe\xd8\xf0\xb2\x07\x77\x8c\xaf\x86\x1e\x67\xbd\xde\xc8\xa8\x6d\xdf\x15\x8b\x2e\xc1\xeb\x11\xbf\x12\x13\x84\x87\xe3\xfd\xc9\x83\xb7\x3b\xca\x38\x80\xfb\xf8\xfc\x03\x8e\x19\x1c\x70\xa2\x56\xe6\x6b\x9c\xf9\xb3\x07\x5c\x35\x23\x3d\x82\xce\xf8\xea\x20\x57\x6e\x8a\xd7\xa4\xaa\xbc\xa9\xf9\x68\xc3\xcf\x9a\x36\x13\x6d\x04\xb2\xa4\xd0\xd5\x84\x78\x18\xc8\xf5\x70\x03\x8b\x33\x65\x5e\x6e\x5f\x62\x4e\xa8\xc3\xe7\xab\x81\x0a\x77\x21\x5f\xe8\xc2\x43\x16\x06\x97\x75\x5a\xa0\x6a\x72\xe9\xb0\xb8\x18\xc8\x22\xcb\xc2\x21\x13\x2a\xaf\xbb\xd1\x66\x3b\x60\x6d\x07\xaf\x1e\x8f\x43\xbd\x9b\x7b\xbf\x92\x91\xcf\x04\xcc\x07\xc7\xaf\x0a\x4b\xb7\xa9\x1b\xf5\xd2\xb6\x1e\xdc\xc2\x67\x1c\x5b\x6f\x1c\x35\x5d\x72\x2a\xdc\xc0\x6b\x70\xbc\x04\x82\x06\x6b\x7b\x92\x89\x47\x48\x22\x82\xc5\x4d\x78\x35\x7f\x29\x09\x64\x06\xd8\x6b\xa0\xad\x18\xd1\xf4\x56\x97\x3d\xba\x53\x8a\x83\x43\xb6\x89\xb5\xc8\x1d\xc5\xf6\x26\x8b\x31\x01\x2c\x34\x97\x19\x4e\x6c\x15\x41\xd0\x41\x5e\xef\x30\xa3\x63\x64\x0b\xd8\x69\x3b\xea\xb2\xef\xe6\xbd\xab\xd8\x8f\x28\x80\x49\x55\xd2\x7d\x2e\x25\x33\xc5\x93\xe7\xa6\xb9\x2d\x90\x14\xe7\x3e\xa6\x61\x89\x49\x7b\x99\x8a\x2c\xa1\xb5\x88\x9e\x0f\xeb\xff\x08\xba\x7b\xcb\xee\x29\x05\xad\x95\xfe\x1f\xb5\xfe\xa7\x90\x77\x80\xed\xaa\xe3\x39\x1a\x91\x78\xd4\x61\x3e\x74\x7e\xbc\xea\xff\x80\xae\x15\xbb\xf3\xdf\x76\x44\x3d\x0b\xc2\x9d\x7c\x6a\x7d\xb4\x0e\x69\x78\xf4\x2f\xc9\xf9\x5c\x07\xe4\x0e\x00\x00")
 
 func staticJsGottyJsBytes() ([]byte, error) {
 	return bindataRead(
@@ -126,7 +126,7 @@ func staticJsGottyJs() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "static/js/gotty.js", size: 2866, mode: os.FileMode(436), modTime: time.Unix(1497268011, 0)}
+	info := bindataFileInfo{name: "static/js/gotty.js", size: 3812, mode: os.FileMode(436), modTime: time.Unix(1792292346, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -0,0 +1,46 @@
+package app
+
+// ringBuffer keeps the last bytes written to it, up to its capacity.
+type ringBuffer struct {
+	data  []byte
+	start int
+	size  int
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, capacity)}
+}
+
+func (b *ringBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	capacity := len(b.data)
+	if capacity == 0 {
+		return n, nil
+	}
+
+	if len(p) > capacity {
+		p = p[len(p)-capacity:]
+	}
+
+	end := (b.start + b.size) % capacity
+	copied := copy(b.data[end:], p)
+	copy(b.data, p[copied:])
+
+	b.size += len(p)
+	if b.size > capacity {
+		b.start = (b.start + b.size - capacity) % capacity
+		b.size = capacity
+	}
+
+	return n, nil
+}
+
+// Bytes returns a copy of the buffered bytes in the order they were written.
+func (b *ringBuffer) Bytes() []byte {
+	result := make([]byte, b.size)
+	copied := copy(result, b.data[b.start:])
+	if copied < b.size {
+		copy(result[copied:], b.data)
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		writes   []string
+		expected string
+	}{
+		{"empty", 8, nil, ""},
+		{"partial", 8, []string{"abc"}, "abc"},
+		{"full", 8, []string{"abcd", "efgh"}, "abcdefgh"},
+		{"overwrite", 8, []string{"abcdef", "ghij"}, "cdefghij"},
+		{"wraparound twice", 4, []string{"ab", "cd", "ef", "gh", "i"}, "fghi"},
+		{"write larger than capacity", 4, []string{"ab", "cdefghij"}, "ghij"},
+		{"write of capacity", 4, []string{"abc", "defg"}, "defg"},
+		{"empty write", 4, []string{"abc", "", "d"}, "abcd"},
+		{"single byte writes", 3, []string{"a", "b", "c", "d", "e"}, "cde"},
+		{"zero capacity", 0, []string{"abc"}, ""},
+	}
+	for _, test := range tests {
+		buffer := newRingBuffer(test.capacity)
+		for _, data := range test.writes {
+			n, err := buffer.Write([]byte(data))
+			if err != nil || n != len(data) {
+				t.Errorf("%s: Write returned %d, %v", test.name, n, err)
+			}
+		}
+		if got := buffer.Bytes(); !bytes.Equal(got, []byte(test.expected)) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestRingBufferReplayOrder(t *testing.T) {
+	buffer := newRingBuffer(10)
+	written := []byte{}
+	for i := 0; i < 100; i++ {
+		data := []byte{byte('a' + i%26), byte('A' + i%26), byte('0' + i%10)}[:1+i%3]
+		buffer.Write(data)
+		written = append(written, data...)
+
+		expected := written
+		if len(expected) > 10 {
+			expected = expected[len(expected)-10:]
+		}
+		if got := buffer.Bytes(); !bytes.Equal(got, expected) {
+			t.Fatalf("After %d writes: expected %q, got %q", i+1, expected, got)
+		}
+	}
+}
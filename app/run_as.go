@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// loginEnvironment lists variables of the server passed to commands run as another user.
+var loginEnvironment = []string{"TERM", "LANG", "LC_ALL", "TZ"}
+
+// defaultUIDMin is the lowest uid of login users when login.defs doesn't give UID_MIN.
+const defaultUIDMin = 1000
+
+// runAsUser returns the unix user to run the command for the authenticated user,
+// or nil to run it as the user of gotty.
+// Authenticated users not in RunAsUserMap can only be login users,
+// whose uid is at least UID_MIN of login.defs, so that names chosen by users
+// don't map to system accounts.
+func (factory *LocalCommandFactory) runAsUser(authenticated string) (*user.User, error) {
+	name := factory.RunAsUser
+	identity := factory.RunAsIdentity && authenticated != ""
+	mapped := false
+	if identity {
+		name = authenticated
+		if mappedName, ok := factory.RunAsUserMap[authenticated]; ok {
+			name = mappedName
+			mapped = true
+		}
+	}
+	if name == "" {
+		if factory.RunAsIdentity {
+			return nil, errors.New("No unix user for anonymous clients")
+		}
+		return nil, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, errors.New("Failed to look up unix user " + name + ": " + err.Error())
+	}
+	if u.Uid == "0" && name != factory.RunAsUser {
+		return nil, errors.New("Refusing to run commands as root for user " + authenticated)
+	}
+	if !identity {
+		return u, nil
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, err
+	}
+	if !mapped && uid < minUserUID() {
+		return nil, errors.New("Refusing to run commands as system account " + name + " for user " + authenticated + ", map it in run_as_user_map")
+	}
+	if shell := filepath.Base(loginShell(u.Username)); shell == "nologin" || shell == "false" {
+		return nil, errors.New("Refusing to run commands as unix user " + name + " without a login shell")
+	}
+	return u, nil
+}
+
+// minUserUID returns UID_MIN of /etc/login.defs, the lowest uid of login users.
+func minUserUID() int {
+	file, err := os.Open("/etc/login.defs")
+	if err != nil {
+		return defaultUIDMin
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "UID_MIN" {
+			if uid, err := strconv.Atoi(fields[1]); err == nil {
+				return uid
+			}
+		}
+	}
+	return defaultUIDMin
+}
+
+// runAs sets up the command to run as the user with its login environment,
+// which consists of HOME, SHELL, USER, LOGNAME and a default PATH
+// in the home directory of the user.
+func runAs(cmd *exec.Cmd, u *user.User) error {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return err
+	}
+
+	if int(uid) != os.Getuid() {
+		groupIDs, err := u.GroupIds()
+		if err != nil {
+			return errors.New("Failed to get groups of unix user " + u.Username + ": " + err.Error())
+		}
+		groups := []uint32{}
+		for _, groupID := range groupIDs {
+			group, err := strconv.ParseUint(groupID, 10, 32)
+			if err != nil {
+				continue
+			}
+			groups = append(groups, uint32(group))
+		}
+
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:    uint32(uid),
+			Gid:    uint32(gid),
+			Groups: groups,
+		}
+	}
+
+	path := "/usr/local/bin:/usr/bin:/bin"
+	if uid == 0 {
+		path = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	}
+	env := []string{
+		"HOME=" + u.HomeDir,
+		"SHELL=" + loginShell(u.Username),
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"PATH=" + path,
+	}
+	for _, name := range loginEnvironment {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	for _, variable := range cmd.Env {
+		if strings.HasPrefix(variable, "GOTTY_") {
+			env = append(env, variable)
+		}
+	}
+	cmd.Env = env
+
+	if info, err := os.Stat(u.HomeDir); err == nil && info.IsDir() {
+		cmd.Dir = u.HomeDir
+	}
+	return nil
+}
+
+// loginShell returns the login shell of the user in /etc/passwd.
+func loginShell(username string) string {
+	file, err := os.Open("/etc/passwd")
+	if err != nil {
+		return "/bin/sh"
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry := strings.Split(scanner.Text(), ":")
+		if len(entry) == 7 && entry[0] == username && entry[6] != "" {
+			return entry[6]
+		}
+	}
+	return "/bin/sh"
+}
@@ -0,0 +1,32 @@
+package app
+
+import "os"
+
+// sandboxInitEnv carries the command to the sandbox init process, which is gotty itself.
+const sandboxInitEnv = "GOTTY_SANDBOX_INIT"
+
+// SandboxConfig configures the namespaces each command runs in.
+type SandboxConfig struct {
+	// Remount all filesystems read-only in the sandbox
+	ReadOnlyRoot bool
+	// Mount an empty tmpfs on the home directory of the user running the command
+	TmpfsHome bool
+	// Start the command in a new network namespace having only the loopback interface
+	IsolateNetwork bool
+}
+
+// IsSandboxInit reports whether this process has been started
+// to set up a sandbox or rlimits and run a command in it.
+// Programs using LocalCommandFactory with a sandbox or limits must call RunSandboxInit in this case
+// before doing anything else.
+func IsSandboxInit() bool {
+	return os.Getenv(sandboxInitEnv) != "" || os.Getenv(rlimitInitEnv) != ""
+}
+
+// sandboxHostname returns the hostname of the sandbox for the session.
+func sandboxHostname(sessionID string) string {
+	if len(sessionID) > 8 {
+		sessionID = sessionID[:8]
+	}
+	return "gotty-" + sessionID
+}
@@ -0,0 +1,352 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// prctl options missing in the syscall package
+const (
+	prSetNoNewPrivs      = 38
+	prCapAmbient         = 47
+	prCapAmbientClearAll = 4
+)
+
+// linuxCapabilityVersion3 is the version of capget and capset with 64-bit capability sets.
+const linuxCapabilityVersion3 = 0x20080522
+
+// sandboxSpec is the command and the sandbox given to the sandbox init process.
+type sandboxSpec struct {
+	Path       string
+	Args       []string
+	Env        []string
+	Dir        string
+	Credential *syscall.Credential
+	Hostname   string
+	Home       string
+	Config     SandboxConfig
+}
+
+// wrap returns a command which starts gotty in new namespaces as the sandbox init process,
+// which sets up the sandbox and runs the command.
+// The credential of the command is applied by the init process after mounting filesystems.
+func (config *SandboxConfig) wrap(cmd *exec.Cmd, sessionID string) (*exec.Cmd, error) {
+	if cmd.Err != nil {
+		return nil, cmd.Err
+	}
+
+	spec := &sandboxSpec{
+		Path:     cmd.Path,
+		Args:     cmd.Args,
+		Env:      cmd.Env,
+		Dir:      cmd.Dir,
+		Hostname: sandboxHostname(sessionID),
+		Config:   *config,
+	}
+	if spec.Env == nil {
+		spec.Env = os.Environ()
+	}
+	for _, variable := range spec.Env {
+		if strings.HasPrefix(variable, "HOME=") {
+			spec.Home = strings.TrimPrefix(variable, "HOME=")
+		}
+	}
+	if cmd.SysProcAttr != nil {
+		spec.Credential = cmd.SysProcAttr.Credential
+	}
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := uintptr(syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC)
+	if config.IsolateNetwork {
+		flags |= syscall.CLONE_NEWNET
+	}
+
+	// Args are kept for the window title and the admin API
+	sandboxed := &exec.Cmd{
+		Path: "/proc/self/exe",
+		Args: cmd.Args,
+		Env:  []string{sandboxInitEnv + "=" + string(encoded)},
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: flags,
+		},
+	}
+	return sandboxed, nil
+}
+
+// RunSandboxInit sets up the sandbox and runs the command in it. It never returns.
+// The process is PID 1 of the new PID namespace, so it forwards signals to the command
+// and reaps orphaned processes until the command exits.
+// Commands limited with rlimits are executed after setting the rlimits.
+func RunSandboxInit() {
+	if os.Getenv(sandboxInitEnv) == "" {
+		runRlimitInit()
+	}
+
+	// Capabilities and no_new_privs are per thread,
+	// so the command must be started from the thread dropping them
+	runtime.LockOSThread()
+
+	spec := &sandboxSpec{}
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxInitEnv)), spec); err != nil {
+		sandboxFail(errors.New("Invalid sandbox specification: " + err.Error()))
+	}
+	os.Unsetenv(sandboxInitEnv)
+
+	if err := spec.setup(); err != nil {
+		sandboxFail(err)
+	}
+	if err := dropPrivileges(); err != nil {
+		sandboxFail(err)
+	}
+
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals)
+
+	process, err := os.StartProcess(spec.Path, spec.Args, &os.ProcAttr{
+		Dir:   spec.Dir,
+		Env:   spec.Env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Sys:   &syscall.SysProcAttr{Credential: spec.Credential},
+	})
+	if err != nil {
+		sandboxFail(err)
+	}
+
+	go func() {
+		for sig := range signals {
+			// SIGURG is used by the Go runtime for preemption
+			if sig == syscall.SIGCHLD || sig == syscall.SIGURG {
+				continue
+			}
+			process.Signal(sig)
+		}
+	}()
+
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			sandboxFail(err)
+		}
+		if pid != process.Pid {
+			continue
+		}
+		if status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+		os.Exit(status.ExitStatus())
+	}
+}
+
+func sandboxFail(err error) {
+	fmt.Fprintf(os.Stderr, "gotty: failed to set up sandbox: %v\r\n", err)
+	os.Exit(1)
+}
+
+// setup mounts filesystems of the sandbox and sets its hostname.
+func (spec *sandboxSpec) setup() error {
+	// Keep mounts in the sandbox from propagating to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return errors.New("Failed to make mounts private: " + err.Error())
+	}
+
+	if spec.Config.ReadOnlyRoot {
+		if err := remountReadOnly(); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return errors.New("Failed to mount /proc: " + err.Error())
+	}
+	if err := mountTmpfs("/tmp", "mode=1777"); err != nil {
+		return err
+	}
+	if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+		if err := mountTmpfs("/dev/shm", "mode=1777"); err != nil {
+			return err
+		}
+	}
+
+	if spec.Config.TmpfsHome && spec.Home != "" && spec.Home != "/" {
+		uid, gid := os.Getuid(), os.Getgid()
+		if spec.Credential != nil {
+			uid, gid = int(spec.Credential.Uid), int(spec.Credential.Gid)
+		}
+		if info, err := os.Stat(spec.Home); err == nil && info.IsDir() {
+			options := "mode=0700,uid=" + strconv.Itoa(uid) + ",gid=" + strconv.Itoa(gid)
+			if err := mountTmpfs(spec.Home, options); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+		return errors.New("Failed to set hostname: " + err.Error())
+	}
+
+	if spec.Config.IsolateNetwork {
+		if err := loopbackUp(); err != nil {
+			return errors.New("Failed to bring up loopback interface: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// dropPrivileges keeps the command from undoing the sandbox.
+// It empties the capability bounding set and the inheritable and ambient sets,
+// and sets no_new_privs, which are all inherited by the command.
+// Commands running as root have no capabilities after exec,
+// so they cannot remount filesystems, and setuid programs give no privileges.
+// The init process keeps its own capabilities to switch to the user of the command.
+func dropPrivileges() error {
+	data, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return errors.New("Failed to read the last capability: " + err.Error())
+	}
+	lastCap, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return errors.New("Failed to read the last capability: " + err.Error())
+	}
+	for capability := 0; capability <= lastCap; capability++ {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_CAPBSET_DROP, uintptr(capability), 0); errno != 0 {
+			return errors.New("Failed to drop capability bounding set: " + errno.Error())
+		}
+	}
+
+	header := struct {
+		version uint32
+		pid     int32
+	}{version: linuxCapabilityVersion3}
+	sets := [2]struct {
+		effective   uint32
+		permitted   uint32
+		inheritable uint32
+	}{}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&sets)), 0); errno != 0 {
+		return errors.New("Failed to get capabilities: " + errno.Error())
+	}
+	sets[0].inheritable, sets[1].inheritable = 0, 0
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&sets)), 0); errno != 0 {
+		return errors.New("Failed to clear inheritable capabilities: " + errno.Error())
+	}
+	// Kernels older than 4.3 have no ambient capabilities
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0); errno != 0 && errno != syscall.EINVAL {
+		return errors.New("Failed to clear ambient capabilities: " + errno.Error())
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return errors.New("Failed to set no_new_privs: " + errno.Error())
+	}
+	return nil
+}
+
+func mountTmpfs(path string, options string) error {
+	if err := syscall.Mount("tmpfs", path, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, options); err != nil {
+		return errors.New("Failed to mount tmpfs on " + path + ": " + err.Error())
+	}
+	return nil
+}
+
+// remountReadOnly makes all mounts read-only, keeping their other flags
+// as a bind remount fails when it clears locked flags.
+func remountReadOnly() error {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	optionFlags := map[string]uintptr{
+		"nosuid":      syscall.MS_NOSUID,
+		"nodev":       syscall.MS_NODEV,
+		"noexec":      syscall.MS_NOEXEC,
+		"noatime":     syscall.MS_NOATIME,
+		"nodiratime":  syscall.MS_NODIRATIME,
+		"relatime":    syscall.MS_RELATIME,
+		"strictatime": syscall.MS_STRICTATIME,
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mountPoint := unescapeMountPoint(fields[4])
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		for _, option := range strings.Split(fields[5], ",") {
+			flags |= optionFlags[option]
+		}
+		if err := syscall.Mount("", mountPoint, "", flags, ""); err != nil {
+			// Mounts hidden by other mounts can't be remounted
+			if mountPoint == "/" {
+				return errors.New("Failed to remount / read-only: " + err.Error())
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// unescapeMountPoint decodes octal escapes such as \040 for spaces in mountinfo.
+func unescapeMountPoint(path string) string {
+	if !strings.Contains(path, "\\") {
+		return path
+	}
+	unescaped := []byte{}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if code, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				unescaped = append(unescaped, byte(code))
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, path[i])
+	}
+	return string(unescaped)
+}
+
+// loopbackUp brings up the loopback interface of the network namespace.
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	request := struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}{}
+	copy(request.name[:], "lo")
+
+	for _, call := range []uintptr{syscall.SIOCGIFFLAGS, syscall.SIOCSIFFLAGS} {
+		if call == syscall.SIOCSIFFLAGS {
+			request.flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), call, uintptr(unsafe.Pointer(&request)))
+		if errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
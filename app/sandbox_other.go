@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func (config *SandboxConfig) wrap(cmd *exec.Cmd, sessionID string) (*exec.Cmd, error) {
+	return nil, errors.New("Sandbox is only supported on Linux")
+}
+
+// RunSandboxInit sets up the sandbox and runs the command in it. It never returns.
+func RunSandboxInit() {
+	fmt.Fprintln(os.Stderr, "gotty: sandbox is only supported on Linux")
+	os.Exit(1)
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is the validity period of generated certificates.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// selfSignedCertificate generates a self-signed certificate.
+// With tls_save_self_signed, the certificate is saved to the crt and key files
+// and reused until it expires.
+// Files holding other certificates are never reused nor overwritten.
+func (app *App) selfSignedCertificate() (*tls.Certificate, error) {
+	crtFile := ExpandHomeDir(app.options.TLSCrtFile)
+	keyFile := ExpandHomeDir(app.options.TLSKeyFile)
+
+	if app.options.TLSSaveSelfSigned {
+		certificate, err := tls.LoadX509KeyPair(crtFile, keyFile)
+		if err == nil {
+			var leaf *x509.Certificate
+			leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+			if err == nil && !isGeneratedSelfSigned(leaf) {
+				err = errors.New("Not a self-signed certificate generated by GoTTY")
+			}
+			if err == nil && time.Now().Before(leaf.NotAfter) {
+				app.logger.infof("Using the self-signed certificate saved in %s", crtFile)
+				return &certificate, nil
+			}
+			if err == nil {
+				app.logger.infof("The self-signed certificate saved in %s has expired", crtFile)
+			}
+		}
+		// Never overwrite files which are not a saved certificate
+		if err != nil && (fileExists(crtFile) || fileExists(keyFile)) {
+			return nil, errors.New("Refusing to overwrite " + crtFile + " and " + keyFile + ": " + err.Error())
+		}
+	}
+
+	hosts := app.selfSignedHosts()
+	crtPEM, keyPEM, err := generateSelfSigned(hosts)
+	if err != nil {
+		return nil, errors.New("Failed to generate self-signed certificate: " + err.Error())
+	}
+	certificate, err := tls.X509KeyPair(crtPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	app.logger.infof("Generated a self-signed certificate for %s", strings.Join(hosts, ", "))
+
+	if app.options.TLSSaveSelfSigned {
+		if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return nil, errors.New("Failed to save self-signed certificate: " + err.Error())
+		}
+		if err := ioutil.WriteFile(crtFile, crtPEM, 0644); err != nil {
+			return nil, errors.New("Failed to save self-signed certificate: " + err.Error())
+		}
+		app.logger.infof("Saved the self-signed certificate to %s and %s", crtFile, keyFile)
+	}
+	return &certificate, nil
+}
+
+// selfSignedHosts returns the names and addresses the server can be accessed with.
+func (app *App) selfSignedHosts() []string {
+	hosts := []string{}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	hosts = append(hosts, "localhost")
+	if app.options.Address != "" {
+		hosts = append(hosts, app.options.Address)
+	}
+	hosts = append(hosts, listAddresses()...)
+	if app.options.ExternalURL != "" {
+		if u, err := url.Parse(app.options.ExternalURL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, host := range hosts {
+		if !seen[host] {
+			seen[host] = true
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+// generateSelfSigned generates a self-signed ECDSA P-256 certificate for the hosts
+// and returns the certificate and the key in PEM.
+func generateSelfSigned(hosts []string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"GoTTY"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	crtPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return crtPEM, keyPEM, nil
+}
+
+// isGeneratedSelfSigned reports whether the certificate has been generated by generateSelfSigned.
+func isGeneratedSelfSigned(certificate *x509.Certificate) bool {
+	if len(certificate.Subject.Organization) != 1 || certificate.Subject.Organization[0] != "GoTTY" {
+		return false
+	}
+	if !bytes.Equal(certificate.RawIssuer, certificate.RawSubject) {
+		return false
+	}
+	return certificate.CheckSignature(certificate.SignatureAlgorithm, certificate.RawTBSCertificate, certificate.Signature) == nil
+}
+
+// certificateFingerprint returns the SHA-256 fingerprint of the certificate
+// in the format shown by browsers and openssl.
+func certificateFingerprint(certificate *tls.Certificate) string {
+	sum := sha256.Sum256(certificate.Certificate[0])
+	hexes := make([]string, len(sum))
+	for i, b := range sum {
+		hexes[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexes, ":")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
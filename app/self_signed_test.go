@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a certificate expiring at notAfter, signed by the parent or self-signed when parent is nil.
+func writeCertificate(t *testing.T, dir string, organization string, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{organization}},
+		NotBefore:             notAfter.Add(-24 * time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, "gotty.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "gotty.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
+
+func selfSignedTestApp(t *testing.T, dir string) *App {
+	options := DefaultOptions
+	options.TLSCrtFile = filepath.Join(dir, "gotty.crt")
+	options.TLSKeyFile = filepath.Join(dir, "gotty.key")
+	options.TLSSaveSelfSigned = true
+	options.LogOutput = ioutil.Discard
+	app, err := New([]string{"true"}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+func TestSelfSignedCertificateSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotty-self-signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	app := selfSignedTestApp(t, dir)
+
+	first, err := app.selfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := app.selfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Certificate[0], second.Certificate[0]) {
+		t.Error("The saved certificate is not reused")
+	}
+
+	writeCertificate(t, dir, "GoTTY", time.Now().Add(-time.Hour), nil, nil)
+	expired, _ := ioutil.ReadFile(app.options.TLSCrtFile)
+	if _, err := app.selfSignedCertificate(); err != nil {
+		t.Fatal(err)
+	}
+	if renewed, _ := ioutil.ReadFile(app.options.TLSCrtFile); bytes.Equal(renewed, expired) {
+		t.Error("The expired self-signed certificate is not renewed")
+	}
+}
+
+func TestSelfSignedCertificateKeepsOtherCertificates(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA", Organization: []string{"GoTTY"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		parent   *x509.Certificate
+		org      string
+	}{
+		{"expired CA-issued", time.Now().Add(-time.Hour), ca, "GoTTY"},
+		{"valid CA-issued", time.Now().Add(time.Hour), ca, "GoTTY"},
+		{"expired self-signed of another organization", time.Now().Add(-time.Hour), nil, "Example"},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "gotty-self-signed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		writeCertificate(t, dir, test.org, test.notAfter, test.parent, caKey)
+		app := selfSignedTestApp(t, dir)
+		original, _ := ioutil.ReadFile(app.options.TLSCrtFile)
+		if _, err := app.selfSignedCertificate(); err == nil {
+			t.Errorf("%s: The certificate is used as a self-signed certificate", test.name)
+		}
+		if current, _ := ioutil.ReadFile(app.options.TLSCrtFile); !bytes.Equal(current, original) {
+			t.Errorf("%s: The certificate is overwritten", test.name)
+		}
+	}
+}
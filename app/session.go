@@ -0,0 +1,416 @@
+package app
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	ResizePolicySmallest = "smallest"
+	ResizePolicyOwner    = "owner"
+)
+
+// session is a slave shared by attached clients.
+// Output from the slave is fanned out to every attached client.
+type session struct {
+	// bytes read from and written to the slave
+	// Use atomic operations.
+	bytesIn  int64
+	bytesOut int64
+
+	app     *App
+	id      string
+	user    string // user who started the session, empty when anonymous
+	slave   Slave
+	created time.Time
+	logger  *logger
+
+	mutex      *sync.Mutex
+	clients    []*clientContext // in order of attachment, the first one is the owner
+	scrollback *ringBuffer      // recent output replayed to attaching clients, nil when disabled
+	recorder   *recorder        // nil when recording is disabled
+	timer      *time.Timer
+	started    bool
+	closed     bool
+
+	// window size applied to the slave, guarded by resizeMutex
+	resizeMutex *sync.Mutex
+	columns     uint16
+	rows        uint16
+}
+
+func (app *App) startSession(params *SlaveParams) (*session, error) {
+	params.SessionID = generateRandomString(32)
+	slave, err := app.factory.New(params)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		app:     app,
+		id:      params.SessionID,
+		user:    params.User,
+		slave:   slave,
+		created: time.Now(),
+		mutex:   &sync.Mutex{},
+
+		resizeMutex: &sync.Mutex{},
+	}
+	s.logger = app.logger.with("session", s.id, "remote_addr", params.RemoteAddr, "user", params.User)
+	if process, ok := slave.(Process); ok {
+		s.logger = s.logger.with("pid", process.Pid())
+	}
+	if (app.options.EnableReattach || app.options.EnableShared) && app.options.ScrollbackSize > 0 {
+		s.scrollback = newRingBuffer(app.options.ScrollbackSize)
+	}
+	if app.options.EnableRecord {
+		name := time.Now().Format("20060102-150405") + "-" + s.id[:8]
+		command, _ := slave.WindowTitleVariables()["Command"].(string)
+		s.recorder, err = newRecorder(app.options.RecordDir, name, command, params.User)
+		if err != nil {
+			s.logger.errorf("Failed to start recording: %v", err)
+		} else {
+			s.logger.infof("Recording to %s", s.recorder.file.Name())
+		}
+	}
+
+	app.sessionsMutex.Lock()
+	app.sessions[s.id] = s
+	app.sessionsMutex.Unlock()
+	atomic.AddInt64(&app.metrics.sessionsStarted, 1)
+
+	return s, nil
+}
+
+// sharedSession returns the session shared by all clients,
+// starting the slave when no client has started it yet.
+func (app *App) sharedSession(params *SlaveParams) (*session, error) {
+	app.sharedMutex.Lock()
+	defer app.sharedMutex.Unlock()
+
+	if app.shared != nil {
+		return app.shared, nil
+	}
+
+	s, err := app.startSession(params)
+	if err != nil {
+		return nil, err
+	}
+	app.shared = s
+	return s, nil
+}
+
+// findSession returns the running session with the given ID, or nil.
+func (app *App) findSession(id string) *session {
+	app.sessionsMutex.Lock()
+	defer app.sessionsMutex.Unlock()
+
+	return app.sessions[id]
+}
+
+// runningSessions returns a snapshot of the running sessions.
+func (app *App) runningSessions() []*session {
+	app.sessionsMutex.Lock()
+	defer app.sessionsMutex.Unlock()
+
+	sessions := make([]*session, 0, len(app.sessions))
+	for _, s := range app.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+func (app *App) closeSessions() {
+	for _, s := range app.runningSessions() {
+		s.close()
+	}
+}
+
+// attachedClients returns a snapshot of the attached clients.
+func (s *session) attachedClients() []*clientContext {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clients := make([]*clientContext, len(s.clients))
+	copy(clients, s.clients)
+	return clients
+}
+
+// info describes the session for the admin API.
+func (s *session) info() sessionInfo {
+	info := sessionInfo{
+		ID:       s.id,
+		Started:  s.created,
+		BytesIn:  atomic.LoadInt64(&s.bytesIn),
+		BytesOut: atomic.LoadInt64(&s.bytesOut),
+		Clients:  []clientInfo{},
+	}
+	if process, ok := s.slave.(Process); ok {
+		info.Pid = process.Pid()
+		info.Argv = process.Argv()
+	}
+	if reporter, ok := s.slave.(LimitReporter); ok {
+		info.Limits, info.LimitMethod = reporter.Limits()
+	}
+
+	s.resizeMutex.Lock()
+	info.Columns, info.Rows = s.columns, s.rows
+	s.resizeMutex.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, client := range s.clients {
+		info.Clients = append(info.Clients, clientInfo{
+			ID:         client.id,
+			RemoteAddr: client.request.RemoteAddr,
+			User:       client.identity.user,
+			Role:       client.identity.role,
+			Connected:  client.connected,
+			BytesIn:    atomic.LoadInt64(&client.bytesIn),
+			BytesOut:   atomic.LoadInt64(&client.bytesOut),
+			Columns:    client.columns,
+			Rows:       client.rows,
+		})
+	}
+	return info
+}
+
+func (s *session) processOutput() {
+	buf := make([]byte, 1024)
+
+	for {
+		size, err := s.slave.Read(buf)
+		if err != nil {
+			s.logger.infof("Command exited")
+			s.close()
+			return
+		}
+
+		atomic.AddInt64(&s.bytesOut, int64(size))
+		atomic.AddInt64(&s.app.metrics.ptyBytesRead, int64(size))
+		if s.recorder != nil {
+			s.recorder.output(buf[:size])
+		}
+
+		s.mutex.Lock()
+		if s.scrollback != nil {
+			s.scrollback.Write(buf[:size])
+		}
+		clients := make([]*clientContext, len(s.clients))
+		copy(clients, s.clients)
+		s.mutex.Unlock()
+
+		for _, client := range clients {
+			if err := client.sendOutput(buf[:size]); err != nil {
+				client.logger.warnf("Failed to send output: %v", err)
+				client.connection.Close()
+			}
+		}
+	}
+}
+
+// attach adds the client to the session and replays the scrollback to it.
+func (s *session) attach(client *clientContext) error {
+	s.mutex.Lock()
+
+	if s.closed {
+		s.mutex.Unlock()
+		return errors.New("Session has already been closed")
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.clients = append(s.clients, client)
+
+	var replay []byte
+	if s.scrollback != nil {
+		replay = s.scrollback.Bytes()
+	}
+
+	// Output is buffered in the slave until the first client attaches
+	if !s.started {
+		s.started = true
+		go s.processOutput()
+	}
+
+	// Hold the write lock of the client so that new output
+	// cannot be sent to it before the replay
+	client.writeMutex.Lock()
+	s.mutex.Unlock()
+	defer client.writeMutex.Unlock()
+
+	if len(replay) == 0 {
+		return nil
+	}
+	atomic.AddInt64(&client.bytesOut, int64(len(replay)))
+	return client.connection.WriteMessage(websocket.TextMessage, outputMessage(replay))
+}
+
+// detach removes the client from the session.
+// The slave is closed when the last client leaves,
+// after the grace period for shared or reattachable sessions.
+func (s *session) detach(client *clientContext) {
+	s.mutex.Lock()
+
+	for i, c := range s.clients {
+		if c == client {
+			s.clients = append(s.clients[:i], s.clients[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.clients) > 0 || s.closed {
+		s.mutex.Unlock()
+		s.resize()
+		return
+	}
+
+	grace := 0
+	if s.app.options.EnableShared {
+		grace = s.app.options.SharedGracePeriod
+	}
+	if s.app.options.EnableReattach && s.app.options.ReattachTime > grace {
+		grace = s.app.options.ReattachTime
+	}
+	if grace > 0 {
+		s.logger.infof("No client attached, closing in %d seconds", grace)
+		s.timer = time.AfterFunc(time.Duration(grace)*time.Second, s.closeIfUnused)
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+
+	s.close()
+}
+
+func (s *session) closeIfUnused() {
+	s.mutex.Lock()
+	unused := len(s.clients) == 0
+	s.mutex.Unlock()
+
+	if unused {
+		s.close()
+	}
+}
+
+func (s *session) close() {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	clients := s.clients
+	s.clients = nil
+	s.mutex.Unlock()
+
+	s.app.sharedMutex.Lock()
+	if s.app.shared == s {
+		s.app.shared = nil
+	}
+	s.app.sharedMutex.Unlock()
+
+	s.app.sessionsMutex.Lock()
+	delete(s.app.sessions, s.id)
+	s.app.sessionsMutex.Unlock()
+
+	s.slave.Close()
+
+	exitCode := -1
+	if process, ok := s.slave.(Process); ok {
+		exitCode = process.ExitCode()
+	}
+	s.app.metrics.sessionClosed(time.Since(s.created), exitCode)
+
+	if s.recorder != nil {
+		if err := s.recorder.close(); err != nil {
+			s.logger.errorf("Failed to close recording: %v", err)
+		}
+	}
+
+	for _, client := range clients {
+		client.connection.Close()
+	}
+}
+
+func (s *session) write(data []byte) error {
+	if s.recorder != nil && s.app.options.RecordInput {
+		s.recorder.input(data)
+	}
+	atomic.AddInt64(&s.bytesIn, int64(len(data)))
+	atomic.AddInt64(&s.app.metrics.ptyBytesWritten, int64(len(data)))
+	_, err := s.slave.Write(data)
+	return err
+}
+
+func (s *session) setWindowSize(client *clientContext, columns uint16, rows uint16) {
+	s.mutex.Lock()
+	client.columns = columns
+	client.rows = rows
+	s.mutex.Unlock()
+
+	s.resize()
+}
+
+// resize applies the window size requested by the attached clients
+// according to the resize policy.
+func (s *session) resize() {
+	s.mutex.Lock()
+	var columns, rows uint16
+	for i, client := range s.clients {
+		if client.columns == 0 || client.rows == 0 {
+			continue
+		}
+		if s.app.options.SharedResizePolicy == ResizePolicyOwner {
+			if i == 0 {
+				columns, rows = client.columns, client.rows
+			}
+			continue
+		}
+		if columns == 0 || client.columns < columns {
+			columns = client.columns
+		}
+		if rows == 0 || client.rows < rows {
+			rows = client.rows
+		}
+	}
+	closed := s.closed
+	s.mutex.Unlock()
+
+	if closed {
+		return
+	}
+
+	s.resizeMutex.Lock()
+	defer s.resizeMutex.Unlock()
+
+	if s.app.options.Height != 0 {
+		rows = uint16(s.app.options.Height)
+	}
+	if s.app.options.Width != 0 {
+		columns = uint16(s.app.options.Width)
+	}
+	if columns == 0 || rows == 0 {
+		return
+	}
+	if columns == s.columns && rows == s.rows {
+		return
+	}
+	s.columns, s.rows = columns, rows
+	if s.recorder != nil {
+		s.recorder.resize(int(columns), int(rows))
+	}
+
+	if err := s.slave.ResizeTerminal(int(columns), int(rows)); err != nil {
+		s.logger.warnf("Failed to resize terminal: %v", err)
+	}
+}
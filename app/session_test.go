@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func reattachTestServer(t *testing.T) (*App, *httptest.Server) {
+	options := DefaultOptions
+	options.EnableReattach = true
+	options.LogOutput = ioutil.Discard
+	app, err := New([]string{"cat"}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(app.Handler(ctx))
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+	return app, server
+}
+
+// connectAs opens a websocket connection as the user, asking for the session,
+// and returns the ID of the session the client is attached to, or an error when the server closes the connection.
+func connectAs(t *testing.T, app *App, server *httptest.Server, user string, sessionID string) (string, error) {
+	ticket, err := app.tickets.issue(httptest.NewRequest("GET", "/", nil), &identity{user: user, role: RoleReadWrite}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/OneAPM/ServerWebConsole/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	init := InitMessage{AuthToken: ticket, SessionID: sessionID}
+	if err := conn.WriteJSON(&init); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return "", err
+		}
+		if len(data) > 0 && data[0] == SetSessionID {
+			return string(data[1:]), nil
+		}
+	}
+}
+
+func TestReattach(t *testing.T) {
+	app, server := reattachTestServer(t)
+
+	sessionID, err := connectAs(t, app, server, "alice", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := connectAs(t, app, server, "bob", sessionID); err == nil {
+		t.Error("Another user is attached to the session")
+	}
+	if _, err := connectAs(t, app, server, "", sessionID); err == nil {
+		t.Error("An anonymous client is attached to the session")
+	}
+
+	reattached, err := connectAs(t, app, server, "alice", sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reattached != sessionID {
+		t.Errorf("Expected session %s, got %s", sessionID, reattached)
+	}
+	if clients := app.findSession(sessionID).attachedClients(); len(clients) != 2 {
+		t.Errorf("Expected 2 clients of alice, got %d", len(clients))
+	}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const shareCookieName = "gotty_share"
+
+// shareLink gives access to the terminal with a role
+// until it expires, is used up or is revoked.
+type shareLink struct {
+	ID      string    `json:"id"`
+	Role    string    `json:"role"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	MaxUses int       `json:"max_uses"` // 0 for unlimited
+	Uses    int       `json:"uses"`
+}
+
+// shareLinkStore keeps share links in memory.
+type shareLinkStore struct {
+	mutex *sync.Mutex
+	links map[string]*shareLink
+}
+
+func newShareLinkStore() *shareLinkStore {
+	return &shareLinkStore{
+		mutex: &sync.Mutex{},
+		links: make(map[string]*shareLink),
+	}
+}
+
+func (store *shareLinkStore) create(role string, lifetime time.Duration, maxUses int) shareLink {
+	now := time.Now()
+	link := &shareLink{
+		ID:      generateRandomString(32),
+		Role:    role,
+		Created: now,
+		Expires: now.Add(lifetime),
+		MaxUses: maxUses,
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.purge()
+	store.links[link.ID] = link
+	return *link
+}
+
+// list returns the links which have not expired, in order of creation.
+func (store *shareLinkStore) list() []shareLink {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.purge()
+	links := make([]shareLink, 0, len(store.links))
+	for _, link := range store.links {
+		links = append(links, *link)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Created.Before(links[j].Created) })
+	return links
+}
+
+func (store *shareLinkStore) revoke(id string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, ok := store.links[id]
+	delete(store.links, id)
+	return ok
+}
+
+// use counts a use of the link.
+func (store *shareLinkStore) use(id string) (shareLink, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	link, ok := store.links[id]
+	if !ok {
+		return shareLink{}, errors.New("Unknown share link")
+	}
+	if time.Now().After(link.Expires) {
+		return shareLink{}, errors.New("Expired share link")
+	}
+	if link.MaxUses > 0 && link.Uses >= link.MaxUses {
+		return shareLink{}, errors.New("Used up share link")
+	}
+	link.Uses++
+	return *link, nil
+}
+
+// valid returns whether clients which came with the link can still connect.
+// Used up links are still valid for the clients which used them.
+func (store *shareLinkStore) valid(id string) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	link, ok := store.links[id]
+	return ok && time.Now().Before(link.Expires)
+}
+
+func (store *shareLinkStore) purge() {
+	now := time.Now()
+	for id, link := range store.links {
+		if now.After(link.Expires) {
+			delete(store.links, id)
+		}
+	}
+}
+
+// wrapShareLinks serves share links, and lets clients which came with a link
+// bypass the authentication of auth.
+func (app *App) wrapShareLinks(site http.Handler, auth http.Handler) http.Handler {
+	prefix := app.path + "/s/"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			app.handleShareLink(w, r, strings.Trim(r.URL.Path[len(prefix):], "/"))
+			return
+		}
+
+		if cookie, err := r.Cookie(shareCookieName); err == nil {
+			id, err := app.tickets.verifySession(ticketKindShare, cookie.Value)
+			if err == nil && app.links.valid(id.link) {
+				site.ServeHTTP(w, withIdentity(w, r, id))
+				return
+			}
+		}
+
+		auth.ServeHTTP(w, r)
+	})
+}
+
+func (app *App) handleShareLink(w http.ResponseWriter, r *http.Request, linkID string) {
+	link, err := app.links.use(linkID)
+	if err != nil {
+		app.logger.with("remote_addr", r.RemoteAddr).warnf("Rejected share link: %v", err)
+		app.metrics.authFailed(authFailureShareLink)
+		http.Error(w, "This link is invalid, expired or already used", http.StatusForbidden)
+		return
+	}
+
+	id := &identity{user: "link " + link.ID[:8], role: link.Role, link: link.ID}
+	lifetime := time.Until(link.Expires)
+	session, err := app.tickets.issueSession(ticketKindShare, id, lifetime)
+	if err != nil {
+		app.logger.errorf("Failed to issue share session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	app.logger.with("remote_addr", r.RemoteAddr).infof("Share link used: %s", id)
+
+	// Share links are opened from other sites, so the cookie cannot be strict
+	http.SetCookie(w, &http.Cookie{
+		Name:     shareCookieName,
+		Value:    session,
+		Path:     app.publicPath(r, app.path+"/"),
+		MaxAge:   int(lifetime / time.Second),
+		Secure:   isSecure(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, app.publicPath(r, app.path+"/"), http.StatusFound)
+}
+
+// disconnectLink closes the connections of clients which came with the link.
+func (app *App) disconnectLink(linkID string) {
+	for _, s := range app.runningSessions() {
+		for _, client := range s.attachedClients() {
+			if client.identity.link == linkID {
+				client.connection.Close()
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShareLinkUses(t *testing.T) {
+	store := newShareLinkStore()
+
+	tests := []struct {
+		name    string
+		maxUses int
+		uses    int // successful uses before the link is used up
+	}{
+		{"single use", 1, 1},
+		{"three uses", 3, 3},
+		{"unlimited", 0, 100},
+	}
+	for _, test := range tests {
+		link := store.create(RoleReadOnly, time.Hour, test.maxUses)
+		for i := 1; i <= test.uses; i++ {
+			used, err := store.use(link.ID)
+			if err != nil {
+				t.Fatalf("%s: use %d: %v", test.name, i, err)
+			}
+			if used.Uses != i || used.Role != RoleReadOnly {
+				t.Errorf("%s: expected use %d of a %s link, got %d of %s", test.name, i, RoleReadOnly, used.Uses, used.Role)
+			}
+		}
+		if test.maxUses > 0 {
+			if _, err := store.use(link.ID); err == nil {
+				t.Errorf("%s: the link is used more than %d times", test.name, test.maxUses)
+			}
+		}
+		// Clients which used the link stay connected
+		if !store.valid(link.ID) {
+			t.Errorf("%s: the used link is not valid", test.name)
+		}
+	}
+}
+
+func TestShareLinkExpiry(t *testing.T) {
+	store := newShareLinkStore()
+	expired := store.create(RoleReadWrite, -time.Second, 0)
+	link := store.create(RoleReadWrite, time.Hour, 0)
+
+	if _, err := store.use(expired.ID); err == nil {
+		t.Error("The expired link is used")
+	}
+	if store.valid(expired.ID) {
+		t.Error("The expired link is valid")
+	}
+	links := store.list()
+	if len(links) != 1 || links[0].ID != link.ID {
+		t.Errorf("Expected only the unexpired link, got %v", links)
+	}
+	if !store.valid(link.ID) {
+		t.Error("The unexpired link is not valid")
+	}
+}
+
+func TestShareLinkRevoke(t *testing.T) {
+	store := newShareLinkStore()
+	link := store.create(RoleReadOnly, time.Hour, 0)
+	other := store.create(RoleReadOnly, time.Hour, 0)
+
+	if !store.revoke(link.ID) {
+		t.Fatal("Failed to revoke the link")
+	}
+	if store.revoke(link.ID) {
+		t.Error("The link is revoked twice")
+	}
+	if store.revoke("unknown") {
+		t.Error("An unknown link is revoked")
+	}
+	if _, err := store.use(link.ID); err == nil {
+		t.Error("The revoked link is used")
+	}
+	if store.valid(link.ID) {
+		t.Error("The revoked link is valid")
+	}
+	if !store.valid(other.ID) {
+		t.Error("Revoking a link revokes another")
+	}
+}
+
+func TestShareLinkList(t *testing.T) {
+	store := newShareLinkStore()
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		ids = append(ids, store.create(RoleReadOnly, time.Hour, 1).ID)
+		time.Sleep(time.Millisecond)
+	}
+	store.use(ids[1])
+
+	links := store.list()
+	if len(links) != len(ids) {
+		t.Fatalf("Expected %d links, got %d", len(ids), len(links))
+	}
+	for i, link := range links {
+		if link.ID != ids[i] {
+			t.Errorf("Expected the link %d to be %s, got %s", i, ids[i], link.ID)
+		}
+	}
+	if links[1].Uses != 1 {
+		t.Errorf("Expected 1 use of the link, got %d", links[1].Uses)
+	}
+}
+
+// adminTestServer builds an app with the admin API and Basic Authentication.
+func adminTestServer(t *testing.T) (*App, *httptest.Server) {
+	options := DefaultOptions
+	options.AdminToken = "admin-token"
+	options.EnableReattach = true
+	options.EnableBasicAuth = true
+	options.Credential = "user:{PLAIN}pass"
+	options.LogOutput = ioutil.Discard
+	app, err := New([]string{"cat"}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(app.Handler(ctx))
+	t.Cleanup(func() {
+		server.Close()
+		cancel()
+	})
+	return app, server
+}
+
+func TestShareLinkHandler(t *testing.T) {
+	app, server := adminTestServer(t)
+	client := noRedirectClient()
+	base := server.URL + "/OneAPM/ServerWebConsole"
+
+	if resp := getWithCookies(t, client, base+"/"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected authentication without a link, got %d", resp.StatusCode)
+	}
+
+	link := app.links.create(RoleReadOnly, time.Hour, 1)
+	resp := getWithCookies(t, client, base+"/s/"+link.ID+"/")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected a redirect for the link, got %d", resp.StatusCode)
+	}
+	cookie := findCookie(resp, shareCookieName)
+	if cookie == nil {
+		t.Fatal("No share cookie is set")
+	}
+	if resp := getWithCookies(t, client, base+"/", cookie); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected access with the share cookie, got %d", resp.StatusCode)
+	}
+
+	if resp := getWithCookies(t, client, base+"/s/"+link.ID+"/"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the used up link to be rejected, got %d", resp.StatusCode)
+	}
+	if resp := getWithCookies(t, client, base+"/s/unknown/"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an unknown link to be rejected, got %d", resp.StatusCode)
+	}
+
+	app.links.revoke(link.ID)
+	if resp := getWithCookies(t, client, base+"/", cookie); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected authentication after the link is revoked, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name   string
+		signal syscall.Signal
+		err    bool
+	}{
+		{"TERM", syscall.SIGTERM, false},
+		{"SIGTERM", syscall.SIGTERM, false},
+		{"sigint", syscall.SIGINT, false},
+		{"Hup", syscall.SIGHUP, false},
+		{"WINCH", syscall.SIGWINCH, false},
+		{"9", syscall.SIGKILL, false},
+		{"15", syscall.SIGTERM, false},
+		{"64", syscall.Signal(64), false},
+		{"", 0, true},
+		{"SIG", 0, true},
+		{"SIGFOO", 0, true},
+		{"SIGSIGTERM", 0, true},
+		{"0", 0, true},
+		{"-9", 0, true},
+		{"65", 0, true},
+		{"9.0", 0, true},
+		{" TERM", 0, true},
+	}
+	for _, test := range tests {
+		signal, err := parseSignal(test.name)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %d", test.name, int(signal))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.name, err)
+			continue
+		}
+		if signal != test.signal {
+			t.Errorf("%q: expected %d, got %d", test.name, int(test.signal), int(signal))
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"io"
+	"syscall"
+)
+
+// Slave is the backend of a session, such as a command running on a PTY.
+// Output read from a slave is sent to clients and input from clients is written to it.
+type Slave interface {
+	io.ReadWriteCloser
+
+	// WindowTitleVariables returns a new map of variables available in the title format.
+	WindowTitleVariables() map[string]interface{}
+	// ResizeTerminal changes the window size of the slave.
+	ResizeTerminal(columns int, rows int) error
+}
+
+// Process is implemented by slaves running a local process,
+// so that the admin API can show and signal it.
+type Process interface {
+	Pid() int
+	Argv() []string
+	Signal(sig syscall.Signal) error
+	// ExitCode returns the exit code of the process after the slave is closed,
+	// or 128 + the signal number when the process is killed by a signal.
+	ExitCode() int
+}
+
+// EchoReporter is implemented by slaves running on a terminal,
+// so that the audit log can suppress input while the terminal doesn't echo it
+// for a password prompt.
+type EchoReporter interface {
+	Echo() (bool, error)
+}
+
+// LimitReporter is implemented by slaves running with limits of resources,
+// so that the admin API can show them.
+type LimitReporter interface {
+	// Limits returns the limits, nil when unlimited, and the method enforcing them.
+	Limits() (*ResourceLimits, string)
+}
+
+// Factory creates a new slave for each session.
+type Factory interface {
+	// Name describes the slaves created by the factory in logs.
+	Name() string
+	New(params *SlaveParams) (Slave, error)
+}
+
+// SlaveParams are the parameters of a session given to a Factory.
+type SlaveParams struct {
+	// Arguments given by the client, only when permit_arguments is enabled
+	Arguments []string
+	// User authenticated by the server, empty when the client is anonymous
+	User string
+	// Address of the client
+	RemoteAddr string
+	// ID of the session the slave is started for
+	SessionID string
+}
@@ -0,0 +1,5 @@
+package app
+
+import "syscall"
+
+const ioctlGetTermios = syscall.TCGETS
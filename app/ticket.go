@@ -0,0 +1,206 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ticketCookieName is the cookie set along with auth_token.js.
+// It carries a secret the ticket is bound to, which scripts cannot read.
+const ticketCookieName = "gotty_ticket"
+
+const (
+	ticketKindWebSocket = "ws"
+	ticketKindSession   = "session" // login session of OpenID Connect
+	ticketKindShare     = "share"   // session opened with a share link
+)
+
+var errTicketCookieMismatch = errors.New("Ticket not bound to the ticket cookie")
+
+// Purposes of signed values.
+// A value signed for one purpose is never accepted for another.
+const (
+	signPurposeTicket    = "ticket"
+	signPurposeOIDCState = "oidc-state"
+)
+
+// ticketIssuer issues tickets to clients loading the index page.
+// A ticket is presented in the init message of the websocket connection
+// and proves that the client has been authenticated by the HTTP layer,
+// so that the credential never leaves the server.
+//
+// A ticket is the base64 encoded payload and its HMAC-SHA256 signature
+// joined with a dot. The signature covers the purpose of the value as well.
+// Tickets are not stored on the server.
+// The same format is used for other values the server gives to clients
+// and verifies later, such as login sessions.
+type ticketIssuer struct {
+	secret   []byte
+	lifetime time.Duration
+	bindIP   bool
+}
+
+type ticketPayload struct {
+	Kind    string `json:"k,omitempty"`
+	User    string `json:"u,omitempty"`
+	Role    string `json:"r,omitempty"` // empty when the role is given by the server configuration
+	Link    string `json:"l,omitempty"` // ID of the share link the user came with
+	Binding string `json:"b,omitempty"` // hash of the secret in the ticket cookie
+	Expires int64  `json:"e"`
+	IP      string `json:"ip,omitempty"`
+}
+
+// newTicketIssuer creates a ticketIssuer.
+// A random secret is used when secret is empty.
+func newTicketIssuer(secret string, lifetime int, bindIP bool) (*ticketIssuer, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ticketIssuer{
+		secret:   key,
+		lifetime: time.Duration(lifetime) * time.Second,
+		bindIP:   bindIP,
+	}, nil
+}
+
+// issue issues a websocket ticket for the identity, which is nil for anonymous clients.
+// The ticket is bound to the cookie secret.
+func (issuer *ticketIssuer) issue(r *http.Request, id *identity, cookieSecret string) (string, error) {
+	payload := ticketPayload{
+		Kind:    ticketKindWebSocket,
+		Binding: ticketBinding(cookieSecret),
+		Expires: time.Now().Add(issuer.lifetime).Unix(),
+	}
+	if id != nil {
+		payload.User, payload.Role, payload.Link = id.user, id.role, id.link
+	}
+	if issuer.bindIP {
+		payload.IP = remoteIP(r)
+	}
+	return issuer.encode(signPurposeTicket, payload)
+}
+
+// verify checks the websocket ticket presented by the client of the request
+// and returns the identity in the ticket.
+// With requireCookie, the request must carry the ticket cookie the ticket is bound to.
+func (issuer *ticketIssuer) verify(r *http.Request, ticket string, requireCookie bool) (*identity, error) {
+	payload, err := issuer.open(ticket, ticketKindWebSocket)
+	if err != nil {
+		return nil, err
+	}
+	if issuer.bindIP && payload.IP != remoteIP(r) {
+		return nil, errors.New("Ticket issued to another address")
+	}
+	if requireCookie {
+		cookie, err := r.Cookie(ticketCookieName)
+		if err != nil || payload.Binding == "" || !hmac.Equal([]byte(ticketBinding(cookie.Value)), []byte(payload.Binding)) {
+			return nil, errTicketCookieMismatch
+		}
+	}
+	return &identity{user: payload.User, role: payload.Role, link: payload.Link}, nil
+}
+
+// issueSession issues a session of the kind for the identity.
+func (issuer *ticketIssuer) issueSession(kind string, id *identity, lifetime time.Duration) (string, error) {
+	return issuer.encode(signPurposeTicket, ticketPayload{
+		Kind:    kind,
+		User:    id.user,
+		Role:    id.role,
+		Link:    id.link,
+		Expires: time.Now().Add(lifetime).Unix(),
+	})
+}
+
+// verifySession returns the identity of a session of the kind.
+func (issuer *ticketIssuer) verifySession(kind string, session string) (*identity, error) {
+	payload, err := issuer.open(session, kind)
+	if err != nil {
+		return nil, err
+	}
+	return &identity{user: payload.User, role: payload.Role, link: payload.Link}, nil
+}
+
+func (issuer *ticketIssuer) open(ticket string, kind string) (*ticketPayload, error) {
+	var payload ticketPayload
+	if err := issuer.decode(signPurposeTicket, ticket, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Kind == "" || payload.Kind != kind {
+		return nil, errors.New("Invalid ticket")
+	}
+	if time.Now().Unix() > payload.Expires {
+		return nil, errors.New("Expired ticket")
+	}
+	return &payload, nil
+}
+
+// encode signs the JSON encoding of the value for the purpose.
+func (issuer *ticketIssuer) encode(purpose string, value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + issuer.sign(purpose, encoded), nil
+}
+
+// decode verifies the signature of a value created by encode for the purpose and decodes it.
+func (issuer *ticketIssuer) decode(purpose string, signed string, value interface{}) error {
+	parts := strings.SplitN(signed, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(issuer.sign(purpose, parts[0]))) {
+		return errors.New("Invalid ticket")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errors.New("Invalid ticket")
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return errors.New("Invalid ticket")
+	}
+	return nil
+}
+
+func (issuer *ticketIssuer) sign(purpose string, data string) string {
+	mac := hmac.New(sha256.New, issuer.secret)
+	mac.Write([]byte(purpose + "\x00" + data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTicketCookieSecret generates a secret for the ticket cookie.
+func newTicketCookieSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// ticketBinding returns the hash of the cookie secret put in tickets,
+// so that tickets don't reveal the secret.
+func ticketBinding(cookieSecret string) string {
+	sum := sha256.Sum256([]byte(cookieSecret))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
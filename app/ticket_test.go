@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTicketWithoutKind(t *testing.T) {
+	issuer, err := newTicketIssuer("", 60, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := issuer.encode(signPurposeTicket, ticketPayload{User: "alice", Expires: time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.verify(httptest.NewRequest("GET", "/ws", nil), ticket, false); err == nil {
+		t.Error("A ticket without a kind is accepted")
+	}
+}
+
+func TestTicketCookieBinding(t *testing.T) {
+	issuer, err := newTicketIssuer("", 60, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := newTicketCookieSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := issuer.issue(httptest.NewRequest("GET", "/auth_token.js", nil), &identity{user: "alice"}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/ws", nil)
+	if _, err := issuer.verify(r, ticket, true); err != errTicketCookieMismatch {
+		t.Errorf("A ticket without the cookie is accepted: %v", err)
+	}
+	r.AddCookie(&http.Cookie{Name: ticketCookieName, Value: ticket})
+	if _, err := issuer.verify(r, ticket, true); err != errTicketCookieMismatch {
+		t.Errorf("The ticket is accepted as the cookie: %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/ws", nil)
+	r.AddCookie(&http.Cookie{Name: ticketCookieName, Value: secret})
+	id, err := issuer.verify(r, ticket, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.user != "alice" {
+		t.Errorf("Expected alice, got %s", id.user)
+	}
+}
+
+func TestAuthTokenCrossSite(t *testing.T) {
+	options := DefaultOptions
+	options.RequireTicketCookie = true
+	app, err := New([]string{"true"}, &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := httptest.NewServer(app.Handler(ctx))
+	defer server.Close()
+
+	tests := []struct {
+		header string
+		value  string
+		status int
+	}{
+		{"", "", http.StatusOK},
+		{"Sec-Fetch-Site", "same-origin", http.StatusOK},
+		{"Sec-Fetch-Site", "cross-site", http.StatusForbidden},
+		{"Sec-Fetch-Site", "same-site", http.StatusForbidden},
+		{"Referer", server.URL + "/OneAPM/ServerWebConsole/", http.StatusOK},
+		{"Referer", "http://evil.example.com/", http.StatusForbidden},
+		{"Origin", "http://evil.example.com", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", server.URL+"/OneAPM/ServerWebConsole/auth_token.js", nil)
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: %s: expected %d, got %d", test.header, test.value, test.status, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		cookie := findCookie(resp, ticketCookieName)
+		if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("Expected an HttpOnly strict ticket cookie, got %v", cookie)
+		} else if strings.Contains(string(body), cookie.Value) {
+			t.Error("The cookie secret is readable by the script")
+		}
+	}
+}
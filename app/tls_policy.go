@@ -0,0 +1,142 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+)
+
+// Modes of client certificate authentication
+const (
+	TLSClientAuthRequire = "require" // clients must present a valid certificate
+	TLSClientAuthRequest = "request" // certificates are verified when presented
+)
+
+// tlsVersions are the values of tls_min_version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves are the values of tls_curves.
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+	"P256":           tls.CurveP256,
+	"P384":           tls.CurveP384,
+	"P521":           tls.CurveP521,
+}
+
+// tlsPolicy is the protocol versions, cipher suites and curves accepted by the server.
+type tlsPolicy struct {
+	minVersion   uint16
+	cipherSuites []uint16 // nil for the defaults of Go
+	curves       []tls.CurveID
+}
+
+// newTLSPolicy parses the TLS options.
+// Cipher suites are given by the standard names, such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+// and insecure suites are refused.
+func newTLSPolicy(options *Options) (*tlsPolicy, error) {
+	policy := &tlsPolicy{}
+
+	version, ok := tlsVersions[options.TLSMinVersion]
+	if !ok {
+		return nil, errors.New("Unknown TLS version: " + options.TLSMinVersion)
+	}
+	policy.minVersion = version
+
+	for _, name := range options.TLSCipherSuites {
+		id, err := cipherSuiteID(name)
+		if err != nil {
+			return nil, err
+		}
+		policy.cipherSuites = append(policy.cipherSuites, id)
+	}
+	if options.EnableHTTP2 && policy.cipherSuites != nil && policy.minVersion < tls.VersionTLS13 && !policy.allowsHTTP2() {
+		return nil, errors.New("HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 in TLS cipher suites")
+	}
+
+	for _, name := range options.TLSCurves {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			return nil, errors.New("Unknown TLS curve: " + name)
+		}
+		policy.curves = append(policy.curves, curve)
+	}
+
+	return policy, nil
+}
+
+func cipherSuiteID(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, nil
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return 0, errors.New("Refusing insecure TLS cipher suite: " + name)
+		}
+	}
+	return 0, errors.New("Unknown TLS cipher suite: " + name)
+}
+
+// allowsHTTP2 reports whether the cipher suites include one required by HTTP/2 (RFC 7540 9.2.2).
+func (policy *tlsPolicy) allowsHTTP2() bool {
+	for _, id := range policy.cipherSuites {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the policy to the config.
+func (policy *tlsPolicy) apply(config *tls.Config) {
+	config.MinVersion = policy.minVersion
+	config.CipherSuites = policy.cipherSuites
+	config.CurvePreferences = policy.curves
+}
+
+// makeTLSConfig builds the TLS config of the server.
+// With HTTP/2, browsers still open websocket connections with HTTP/1.1,
+// since the server doesn't support websockets over HTTP/2.
+func (app *App) makeTLSConfig(certificates *certificateStore) (*tls.Config, error) {
+	policy, err := newTLSPolicy(app.options)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		NextProtos:     []string{"http/1.1"},
+		GetCertificate: certificates.getCertificate,
+	}
+	if app.options.EnableHTTP2 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	policy.apply(tlsConfig)
+
+	if app.options.EnableTLSClientAuth {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if app.options.TLSClientAuthMode == TLSClientAuthRequest {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		tlsConfig.VerifyConnection = app.verifyClientConnection
+		tlsConfig.GetConfigForClient = certificates.configForClient(tlsConfig.Clone())
+	}
+
+	app.logger.infof("TLS minimum version: %s", app.options.TLSMinVersion)
+	if len(app.options.TLSCipherSuites) > 0 {
+		app.logger.infof("TLS cipher suites: %s", strings.Join(app.options.TLSCipherSuites, ", "))
+	}
+	if len(app.options.TLSCurves) > 0 {
+		app.logger.infof("TLS curves: %s", strings.Join(app.options.TLSCurves, ", "))
+	}
+	if app.options.EnableHTTP2 {
+		app.logger.infof("Serving HTTP/2 over TLS")
+	}
+	return tlsConfig, nil
+}
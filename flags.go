@@ -59,6 +59,16 @@ func generateFlags(flags []flag, hint map[string]string) ([]cli.Flag, error) {
 				Usage:  flag.description,
 				EnvVar: envName,
 			}
+		case reflect.Slice:
+			if _, ok := field.Value().([]string); !ok {
+				return nil, errors.New("Unsupported type: " + fieldName)
+			}
+			results[i] = cli.StringSliceFlag{
+				Name:   flagName,
+				Value:  &cli.StringSlice{},
+				Usage:  flag.description,
+				EnvVar: envName,
+			}
 		default:
 			return nil, errors.New("Unsupported type: " + fieldName)
 		}
@@ -85,6 +95,8 @@ func applyFlags(
 				val = c.Bool(flag.name)
 			case reflect.Int:
 				val = c.Int(flag.name)
+			case reflect.Slice:
+				val = c.StringSlice(flag.name)
 			}
 			field.Set(val)
 		}
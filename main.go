@@ -12,6 +12,11 @@ import (
 )
 
 func main() {
+	// Sandboxed commands are started by gotty itself in new namespaces
+	if app.IsSandboxInit() {
+		app.RunSandboxInit()
+	}
+
 	cmd := cli.NewApp()
 	cmd.Version = app.Version
 	cmd.Name = "gotty"
@@ -21,15 +26,42 @@ func main() {
 	flags := []flag{
 		flag{"address", "a", "IP address to listen"},
 		flag{"port", "p", "Port number to listen"},
+		flag{"unix-socket", "", "Path of a Unix domain socket to listen on instead of the TCP port"},
+		flag{"unix-socket-mode", "", "Permissions of the Unix domain socket in octal"},
+		flag{"unix-socket-group", "", "Group owning the Unix domain socket (default: the group of gotty)"},
+		flag{"trusted-proxy", "", "IP address or CIDR of a proxy whose X-Forwarded-* headers are honored, can be repeated (\"unix\" for Unix socket clients)"},
+		flag{"proxy-protocol", "", "Read the PROXY protocol header of connections from trusted proxies"},
+		flag{"external-url", "", "Base URL of the server as seen by clients, printed and used for absolute links"},
 		flag{"permit-write", "w", "Permit clients to write to the TTY (BE CAREFUL)"},
 		flag{"credential", "c", "Credential for Basic Authentication (ex: user:pass, default disabled)"},
+		flag{"credential-file", "", "Htpasswd file with credentials for Basic Authentication (default disabled)"},
+		flag{"oidc-issuer", "", "OpenID Connect issuer URL to authenticate users with (default disabled)"},
+		flag{"oidc-client-id", "", "OpenID Connect client ID"},
+		flag{"oidc-client-secret", "", "OpenID Connect client secret"},
+		flag{"oidc-redirect-url", "", "OpenID Connect redirect URL (default: <URL>/oidc/callback)"},
+		flag{"metrics", "", "Serve Prometheus metrics at /metrics"},
+		flag{"admin-token", "", "Bearer token for the admin API (default disabled)"},
+		flag{"ticket-lifetime", "", "Seconds a websocket ticket issued to the index page is valid"},
+		flag{"ticket-bind-ip", "", "Accept websocket tickets only from the address they were issued to"},
+		flag{"allowed-origin", "", "Origin allowed to open websocket connections, can be repeated (default same origin only)"},
+		flag{"require-ticket-cookie", "", "Require the ticket cookie set by the index page to open websocket connections"},
 		flag{"fixed-url", "f", "Add a Fixed string to the URL"},
 		flag{"random-url", "r", "Add a random string to the URL"},
 		flag{"random-url-length", "", "Random URL length"},
 		flag{"tls", "t", "Enable TLS/SSL"},
 		flag{"tls-crt", "", "TLS/SSL certificate file path"},
 		flag{"tls-key", "", "TLS/SSL key file path"},
+		flag{"tls-self-signed", "", "Generate a self-signed certificate at startup instead of loading the TLS/SSL crt and key files"},
+		flag{"tls-save-self-signed", "", "Save the self-signed certificate to the TLS/SSL crt and key files and reuse it until it expires"},
+		flag{"tls-min-version", "", "Minimum TLS version (\"1.0\", \"1.1\", \"1.2\" or \"1.3\")"},
+		flag{"tls-cipher-suite", "", "TLS cipher suite for TLS 1.2 and earlier, can be repeated (default: the defaults of Go)"},
+		flag{"tls-curve", "", "Elliptic curve for key exchange (X25519, X25519MLKEM768, P256, P384 or P521), can be repeated"},
+		flag{"http2", "", "Serve HTTP/2 over TLS, websockets still use HTTP/1.1"},
 		flag{"tls-ca-crt", "", "TLS/SSL CA certificate file for client certifications"},
+		flag{"tls-extra-ca-crt", "", "Additional CA certificate file for client certifications, can be repeated"},
+		flag{"tls-client-auth-mode", "", "Client certificates are required (\"require\") or verified only when given (\"request\")"},
+		flag{"tls-crl", "", "CRL file to check revocation of client certificates"},
+		flag{"tls-client-user-field", "", "Field of client certificates identifying users (cn, email, dns, uri or an OID)"},
 		flag{"index", "", "Custom index.html file"},
 		flag{"title-format", "", "Title format of browser window"},
 		flag{"reconnect", "", "Enable reconnection"},
@@ -41,16 +73,64 @@ func main() {
 		flag{"close-signal", "", "Signal sent to the command process when gotty close it (default: SIGHUP)"},
 		flag{"width", "", "Static width of the screen, 0(default) means dynamically resize"},
 		flag{"height", "", "Static height of the screen, 0(default) means dynamically resize"},
+		flag{"shared", "", "Share a single command with all clients"},
+		flag{"shared-resize-policy", "", "Window size of the shared command (\"smallest\" or \"owner\")"},
+		flag{"shared-grace-period", "", "Seconds to keep the shared command after the last client leaves"},
+		flag{"reattach", "", "Keep commands after disconnection so that clients can reattach to them"},
+		flag{"reattach-time", "", "Seconds to keep a command without clients for reattachment"},
+		flag{"scrollback-size", "", "Bytes of recent output replayed to reattaching clients"},
+		flag{"record", "", "Record sessions in the asciicast v2 format"},
+		flag{"record-dir", "", "Directory to store session recordings"},
+		flag{"record-input", "", "Record input from clients as well as output"},
+		flag{"playback", "", "Serve recorded sessions at <URL>/playback/"},
+		flag{"audit-log", "", "File to append input from clients to, or \"syslog\" or \"syslog://<host>:<port>\" (default disabled)"},
+		flag{"audit-mode", "", "Audit input as \"raw\" bytes or reconstructed \"line\"s"},
+		flag{"audit-record-no-echo", "", "Audit input while the terminal doesn't echo it, such as passwords"},
+		flag{"limit-cpu", "", "Percentage of a CPU each command can use, 0(default) means no limit"},
+		flag{"limit-memory", "", "Megabytes of memory each command can use, 0(default) means no limit"},
+		flag{"limit-pids", "", "Number of processes each command can run, 0(default) means no limit"},
+		flag{"cgroup-parent", "", "Parent cgroup v2 of commands under /sys/fs/cgroup (default: the cgroup of gotty)"},
+		flag{"run-as-user", "", "Unix user to run the command as (default: the user of gotty)"},
+		flag{"run-as-identity", "", "Run the command as the unix user named after the authenticated user"},
+		flag{"sandbox", "", "Run the command in new namespaces with a read-only root and a tmpfs home (Linux only, requires root)"},
+		flag{"sandbox-no-network", "", "Run the sandboxed command without network access"},
+		flag{"log-format", "", "Log format (\"text\" or \"json\")"},
+		flag{"log-level", "", "Minimum level of logs (\"debug\", \"info\", \"warn\" or \"error\")"},
 	}
 
 	mappingHint := map[string]string{
-		"index":      "IndexFile",
-		"tls":        "EnableTLS",
-		"tls-crt":    "TLSCrtFile",
-		"tls-key":    "TLSKeyFile",
-		"tls-ca-crt": "TLSCACrtFile",
-		"random-url": "EnableRandomUrl",
-		"reconnect":  "EnableReconnect",
+		"index":                 "IndexFile",
+		"tls":                   "EnableTLS",
+		"tls-crt":               "TLSCrtFile",
+		"tls-key":               "TLSKeyFile",
+		"tls-self-signed":       "TLSSelfSigned",
+		"tls-save-self-signed":  "TLSSaveSelfSigned",
+		"tls-min-version":       "TLSMinVersion",
+		"tls-cipher-suite":      "TLSCipherSuites",
+		"tls-curve":             "TLSCurves",
+		"http2":                 "EnableHTTP2",
+		"tls-ca-crt":            "TLSCACrtFile",
+		"tls-extra-ca-crt":      "TLSExtraCACrtFiles",
+		"tls-client-auth-mode":  "TLSClientAuthMode",
+		"tls-crl":               "TLSCRLFile",
+		"tls-client-user-field": "TLSClientUserField",
+		"random-url":            "EnableRandomUrl",
+		"reconnect":             "EnableReconnect",
+		"shared":                "EnableShared",
+		"reattach":              "EnableReattach",
+		"record":                "EnableRecord",
+		"playback":              "EnablePlayback",
+		"metrics":               "EnableMetrics",
+		"sandbox":               "EnableSandbox",
+		"limit-cpu":             "LimitCPU",
+		"ticket-bind-ip":        "TicketBindIP",
+		"allowed-origin":        "AllowedOrigins",
+		"trusted-proxy":         "TrustedProxies",
+		"external-url":          "ExternalURL",
+		"oidc-issuer":           "OIDCIssuer",
+		"oidc-client-id":        "OIDCClientID",
+		"oidc-client-secret":    "OIDCClientSecret",
+		"oidc-redirect-url":     "OIDCRedirectURL",
 	}
 
 	cliFlags, err := generateFlags(flags, mappingHint)
@@ -70,7 +150,7 @@ func main() {
 
 	cmd.Action = func(c *cli.Context) {
 		if len(c.Args()) == 0 {
-			fmt.Println("Error: No command given.\n")
+			fmt.Print("Error: No command given.\n\n")
 			cli.ShowAppHelp(c)
 			exit(err, 1)
 		}
@@ -87,10 +167,13 @@ func main() {
 
 		applyFlags(&options, flags, mappingHint, c)
 
-		if c.IsSet("credential") {
+		if c.IsSet("credential") || c.IsSet("credential-file") {
 			options.EnableBasicAuth = true
 		}
-		if c.IsSet("tls-ca-crt") {
+		if c.IsSet("oidc-issuer") {
+			options.EnableOIDC = true
+		}
+		if c.IsSet("tls-ca-crt") || c.IsSet("tls-extra-ca-crt") {
 			options.EnableTLSClientAuth = true
 		}
 
@@ -103,7 +186,7 @@ func main() {
 			exit(err, 3)
 		}
 
-		registerSignals(app)
+		registerSignals(app, options.EnableTLS)
 
 		err = app.Run()
 		if err != nil {
@@ -123,13 +206,17 @@ func exit(err error, code int) {
 	os.Exit(code)
 }
 
-func registerSignals(app *app.App) {
+func registerSignals(app *app.App, reloadOnHangup bool) {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(
 		sigChan,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 	)
+	// SIGHUP reloads TLS certificates instead of terminating gotty
+	if reloadOnHangup {
+		signal.Notify(sigChan, syscall.SIGHUP)
+	}
 
 	go func() {
 		for {
@@ -141,6 +228,8 @@ func registerSignals(app *app.App) {
 				} else {
 					os.Exit(5)
 				}
+			case syscall.SIGHUP:
+				app.ReloadCertificates()
 			}
 		}
 	}()
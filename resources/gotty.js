@@ -4,6 +4,7 @@
     var url = (httpsEnabled ? 'wss://' : 'ws://') + window.location.host + window.location.pathname + 'ws';
     var protocols = ["gotty"];
     var autoReconnect = -1;
+    var sessionId = window.sessionStorage.getItem("gotty-session-id");
 
     var openWs = function() {
         var ws = new WebSocket(url, protocols);
@@ -13,7 +14,7 @@
         var pingTimer;
 
         ws.onopen = function(event) {
-            ws.send(JSON.stringify({ Arguments: args, AuthToken: gotty_auth_token,}));
+            ws.send(JSON.stringify({ Arguments: args, AuthToken: gotty_auth_token, SessionID: sessionId,}));
             pingTimer = setInterval(sendPing, 30 * 1000, ws);
 
             hterm.defaultStorage = new lib.Storage.Local();
@@ -72,6 +73,14 @@
                 autoReconnect = JSON.parse(data);
                 console.log("Enabling reconnect: " + autoReconnect + " seconds")
                 break;
+            case '5':
+                sessionId = data;
+                window.sessionStorage.setItem("gotty-session-id", sessionId);
+                break;
+            case '6':
+                var overlay = JSON.parse(data);
+                term.io.showOverlay(overlay.message, overlay.duration);
+                break;
             }
         };
 
@@ -82,11 +91,26 @@
             }
             clearInterval(pingTimer);
             if (autoReconnect > 0) {
-                setTimeout(openWs, autoReconnect * 1000);
+                setTimeout(reconnect, autoReconnect * 1000);
             }
         };
     }
 
+    // Tickets are short-lived, get a new one before reconnecting
+    var reconnect = function() {
+        var script = document.createElement("script");
+        script.src = "./auth_token.js?" + Date.now();
+        script.onload = function() {
+            document.head.removeChild(script);
+            openWs();
+        };
+        script.onerror = function() {
+            document.head.removeChild(script);
+            setTimeout(reconnect, autoReconnect * 1000);
+        };
+        document.head.appendChild(script);
+    }
+
 
     var sendPing = function(ws) {
         ws.send("1");